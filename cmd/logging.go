@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"l4-load-balancer/internal/config"
+	"l4-load-balancer/internal/logging"
+)
+
+// setupLogging makes the configured logger the default and returns the
+// access logger, or nil if the access log is off. A rotating access log
+// file is reopened on SIGUSR1.
+func setupLogging(lc config.LoggingConfig) (*slog.Logger, error) {
+	logger, err := logging.New(os.Stderr, lc.Level, lc.Format)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+
+	ac := lc.Access
+	if ac == nil {
+		return nil, nil
+	}
+	format := ac.Format
+	if format == "" {
+		format = lc.Format
+	}
+	if ac.File == "" {
+		return logging.New(os.Stderr, "info", format)
+	}
+
+	file, err := logging.OpenRotatingFile(ac.File, int64(ac.MaxSizeMB)*1024*1024, ac.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	access, err := logging.New(file, "info", format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	go watchReopen(file)
+	return access, nil
+}
+
+// watchReopen reopens the access log file whenever the process receives
+// SIGUSR1, for use with external log rotation
+func watchReopen(file *logging.RotatingFile) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		if err := file.Reopen(); err != nil {
+			slog.Error("Failed to reopen access log", "error", err)
+			continue
+		}
+		slog.Info("Reopened access log")
+	}
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,17 +1,540 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"l4-load-balancer/internal/acl"
+	"l4-load-balancer/internal/admin"
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/config"
+	"l4-load-balancer/internal/health"
+	"l4-load-balancer/internal/metrics"
+	"l4-load-balancer/internal/ratelimit"
+	"l4-load-balancer/internal/sniff"
+	"l4-load-balancer/pkg/dialer"
 )
 
 func main() {
+	configPath := flag.String("config", "", "Path to the YAML configuration file")
+	flag.Parse()
+
 	fmt.Println("L4 Load Balancer starting...")
 
-	// TODO: Initialize configuration
-	// TODO: Initialize backend pool
-	// TODO: Initialize health checker
-	// TODO: Start load balancer server
+	cfg := config.GetDefaultConfig()
+	if *configPath != "" {
+		var err error
+		cfg, err = config.LoadConfig(*configPath)
+		if err != nil {
+			fatal("Failed to load configuration", "error", err)
+		}
+	}
+
+	accessLog, err := setupLogging(cfg.Logging)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	tracer, err := newTracer(cfg.Tracing)
+	if err != nil {
+		fatal("Invalid tracing configuration", "error", err)
+	}
+
+	defaultPool, err := newPool(config.PoolConfig{
+		Name:      balancer.DefaultPoolName,
+		Algorithm: cfg.LoadBalancer.Algorithm,
+		Backends:  cfg.Backends,
+		Timeouts:  cfg.LoadBalancer.Timeouts,
+		Retry:     cfg.LoadBalancer.Retry,
+		SlowStart: cfg.LoadBalancer.SlowStart,
+		Affinity:  cfg.LoadBalancer.Affinity,
+		Failover:  cfg.LoadBalancer.Failover,
+		Locality:  cfg.LoadBalancer.Locality,
+		Mirror:    cfg.LoadBalancer.Mirror,
+
+		CircuitBreaker: cfg.LoadBalancer.CircuitBreaker,
+		ConnectionPool: cfg.LoadBalancer.ConnectionPool,
+		Dialer:         cfg.LoadBalancer.Dialer,
+		Bandwidth:      cfg.LoadBalancer.Bandwidth,
+	}, cfg.HealthCheck, cfg.LoadBalancer.Zone)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	lb := balancer.NewLoadBalancer(cfg.LoadBalancer.ListenAddress, nil, nil)
+	lb.SetDefaultPool(defaultPool.Balancer)
+	lb.SetAccessLog(accessLog)
+	lb.SetTracer(tracer)
+	pools := []*admin.Pool{defaultPool}
+
+	for _, pc := range cfg.Pools {
+		pool, err := newPool(pc, cfg.HealthCheck, cfg.LoadBalancer.Zone)
+		if err != nil {
+			fatal("Invalid pool configuration", "pool", pc.Name, "error", err)
+		}
+		lb.AddPool(pool.Balancer)
+		pools = append(pools, pool)
+	}
+
+	splits, err := newSplits(cfg.LoadBalancer.Splits, pools)
+	if err != nil {
+		fatal("Invalid split configuration", "error", err)
+	}
+	for _, split := range splits {
+		lb.AddSplit(split)
+	}
+
+	if cfg.LoadBalancer.Sniffing != nil {
+		sniffer, err := newSniffer(cfg.LoadBalancer.Sniffing)
+		if err != nil {
+			fatal("Invalid sniffing configuration", "error", err)
+		}
+		lb.SetRouter(sniffer)
+	}
+
+	if cfg.LoadBalancer.ACL != nil {
+		list, err := newACL(cfg.LoadBalancer.ACL)
+		if err != nil {
+			fatal("Invalid ACL configuration", "error", err)
+		}
+		lb.SetAccessController(list)
+	}
+
+	if cfg.LoadBalancer.Limits != nil {
+		limiter, err := newLimiter(cfg.LoadBalancer.Limits)
+		if err != nil {
+			fatal("Invalid limits configuration", "error", err)
+		}
+		lb.SetConnLimiter(limiter)
+	}
+
+	if cfg.Metrics.ListenAddress != "" {
+		go serveMetrics(cfg.Metrics)
+	}
+
+	adminServer := newAdminServer(cfg, *configPath, pools, splits)
+	if cfg.Admin.ListenAddress != "" {
+		go serveAdmin(adminServer, cfg.Admin.ListenAddress)
+	}
+
+	if *configPath != "" {
+		go watchReload(*configPath, cfg, pools, splits, adminServer)
+	}
+
+	go watchShutdown(lb)
+
+	slog.Info("Load balancer is running", "listen_address", cfg.LoadBalancer.ListenAddress)
+	err = lb.Start()
+	for _, pool := range pools {
+		if pool.ConnectionPools != nil {
+			pool.ConnectionPools.Close()
+		}
+	}
+	if tracer != nil {
+		shutdownTracer(tracer)
+	}
+	if err != nil {
+		fatal("Load balancer stopped", "error", err)
+	}
+	slog.Info("Load balancer stopped")
+}
+
+// watchShutdown stops accepting connections when the process receives
+// SIGINT or SIGTERM
+func watchShutdown(lb *balancer.LoadBalancer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	slog.Info("Shutting down", "signal", sig.String())
+	lb.Close()
+}
+
+// newManager creates a backend manager holding the configured servers,
+// connecting to them with d
+func newManager(backends []config.BackendConfig, d dialer.Dialer) (*backend.Manager, error) {
+	manager := backend.NewManager()
+	manager.SetDialer(d)
+	for _, bc := range backends {
+		if err := backend.CheckAddress(backend.JoinAddress(bc.Address, bc.Port)); err != nil {
+			return nil, fmt.Errorf("backend: %w", err)
+		}
+		tlsConfig, err := bc.TLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("TLS settings for backend %s:%d: %w", bc.Address, bc.Port, err)
+		}
+		manager.AddServerWithOptions(bc.Address, bc.Port, backend.ServerOptions{
+			Weight:    bc.Weight,
+			Priority:  bc.Priority,
+			Zone:      bc.Zone,
+			TLSConfig: tlsConfig,
+		})
+	}
+	return manager, nil
+}
+
+// startChecker runs health checks for the pool's servers in the background
+func startChecker(pool string, manager *backend.Manager, hc config.HealthCheckConfig) *health.Checker {
+	checker := health.NewPoolChecker(pool, manager, hc.Interval, hc.Timeout)
+	go checker.Start()
+	return checker
+}
+
+// newPool builds a health-checked balancer pool from its configuration,
+// preferring backends in zone when it is set
+func newPool(pc config.PoolConfig, hc config.HealthCheckConfig, zone string) (*admin.Pool, error) {
+	algorithm, err := balancer.NewAlgorithm(pc.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if zone != "" {
+		algorithm = balancer.NewZoneAwareAlgorithm(algorithm, zone, pc.Locality.MinLocalPercent)
+	}
+	algorithm = balancer.NewPriorityAlgorithm(algorithm, pc.Failover.MinHealthy, pc.Failover.MinHealthyPercent)
+	d, err := newDialer(pc.Dialer)
+	if err != nil {
+		return nil, err
+	}
+	manager, err := newManager(pc.Backends, d)
+	if err != nil {
+		return nil, err
+	}
+	pool := balancer.NewPool(pc.Name, algorithm, func() []balancer.Backend {
+		return backendsFromManager(manager)
+	})
+	manager.AddObserver(removalObserver{pool: pool})
+	pool.SetDialer(d)
+	pool.SetTimeouts(newTimeouts(pc.Timeouts))
+	pool.SetRetryPolicy(newRetryPolicy(pc.Retry))
+	pool.SetSlowStart(balancer.SlowStart{
+		Window:           pc.SlowStart.Window,
+		MinWeightPercent: pc.SlowStart.MinWeightPercent,
+		Aggression:       pc.SlowStart.Aggression,
+	})
+	if pc.Affinity != nil {
+		pool.SetAffinity(balancer.NewAffinityTable(pc.Affinity.TTL, pc.Affinity.Size))
+	}
+	if pc.Mirror != nil {
+		mirror, err := newMirror(pc.Mirror)
+		if err != nil {
+			return nil, err
+		}
+		mirror.Dialer = d
+		pool.SetMirror(mirror)
+	}
+	if cb := pc.CircuitBreaker; cb != nil {
+		pool.SetCircuitBreaker(balancer.BreakerPolicy{
+			FailureThreshold:   cb.FailureThreshold,
+			OpenDuration:       cb.OpenDuration,
+			HalfOpenTrials:     cb.HalfOpenTrials,
+			EarlyFailureWindow: cb.EarlyFailureWindow,
+		})
+	}
+	if bc := pc.Bandwidth; bc != nil {
+		throttle, err := newThrottle(bc)
+		if err != nil {
+			return nil, err
+		}
+		pool.SetBandwidth(throttle)
+	}
+
+	var connPools *backend.PoolRegistry
+	if cp := pc.ConnectionPool; cp != nil {
+		connPools = backend.NewPoolRegistry(manager, backend.PoolOptions{
+			Name:        pc.Name,
+			MaxSize:     cp.MaxSize,
+			MinIdle:     cp.MinIdle,
+			MaxIdleTime: cp.MaxIdleTime,
+			MaxLifetime: cp.MaxLifetime,
+			DialTimeout: pc.Timeouts.Connect,
+			Dialer:      pool.BackendDialer(),
+		})
+		pool.SetConnectionPools(connPools.Pool)
+	}
+	checker := startChecker(pc.Name, manager, hc)
+
+	return &admin.Pool{
+		Name:     pc.Name,
+		Manager:  manager,
+		Checker:  checker,
+		Balancer: pool,
+
+		ConnectionPools: connPools,
+	}, nil
+}
+
+// removalObserver tells a balancer pool when one of its manager's servers
+// is removed, so the pool can drop what it keeps about that backend
+type removalObserver struct {
+	pool *balancer.Pool
+}
+
+func (o removalObserver) ServerAdded(*backend.Server)         {}
+func (o removalObserver) HealthChanged(*backend.Server, bool) {}
+func (o removalObserver) ServerRemoved(server *backend.Server) {
+	o.pool.ForgetBackend(server.GetAddress())
+}
+
+// newDialer builds the dialer for a pool's backends, or returns nil to use
+// the default
+func newDialer(dc *config.DialerConfig) (dialer.Dialer, error) {
+	if dc == nil {
+		return nil, nil
+	}
+	return dialer.New(dialer.Options{
+		SourceAddress: dc.SourceAddress,
+		Interface:     dc.Interface,
+		Mark:          dc.Mark,
+	})
+}
+
+// newMirror builds a shadow backend mirror, mirroring every connection
+// unless a sample percentage is set
+func newMirror(mc *config.MirrorConfig) (*balancer.Mirror, error) {
+	if err := backend.CheckAddress(mc.Address); err != nil {
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+	tlsConfig, err := mc.TLS.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("TLS settings for mirror %s: %w", mc.Address, err)
+	}
+	sample := mc.SamplePercent
+	if sample <= 0 {
+		sample = 100
+	}
+	mirror := balancer.NewMirror(mc.Address, sample, mc.BufferSize)
+	mirror.TLSConfig = tlsConfig
+	return mirror, nil
+}
+
+// newRetryPolicy converts retry settings, filling in the default budget
+func newRetryPolicy(rc config.RetryConfig) balancer.RetryPolicy {
+	policy := balancer.RetryPolicy{
+		Attempts:            rc.Attempts,
+		PerTryTimeout:       rc.PerTryTimeout,
+		BudgetPercent:       rc.BudgetPercent,
+		MinRetriesPerSecond: rc.MinRetriesPerSecond,
+	}
+	if policy.BudgetPercent <= 0 {
+		policy.BudgetPercent = 20
+	}
+	if policy.MinRetriesPerSecond <= 0 {
+		policy.MinRetriesPerSecond = 3
+	}
+	return policy
+}
+
+// newTimeouts converts timeout settings, filling in defaults
+func newTimeouts(tc config.TimeoutsConfig) balancer.Timeouts {
+	timeouts := balancer.DefaultTimeouts()
+	if tc.Connect > 0 {
+		timeouts.Connect = tc.Connect
+	}
+	timeouts.Idle = tc.Idle
+	timeouts.MaxLifetime = tc.MaxLifetime
+	timeouts.KeepAlive = net.KeepAliveConfig{
+		Enable:   !tc.KeepAlive.Disabled,
+		Idle:     tc.KeepAlive.Idle,
+		Interval: tc.KeepAlive.Interval,
+		Count:    tc.KeepAlive.Count,
+	}
+	return timeouts
+}
+
+// newSplits builds the configured traffic splits between pools
+func newSplits(scs []config.SplitConfig, pools []*admin.Pool) ([]*balancer.Split, error) {
+	known := make(map[string]bool, len(pools))
+	for _, pool := range pools {
+		known[pool.Name] = true
+	}
+
+	splits := make([]*balancer.Split, 0, len(scs))
+	for _, sc := range scs {
+		if !known[sc.Pool] {
+			return nil, fmt.Errorf("split %s: unknown pool %q", sc.Name, sc.Pool)
+		}
+		variants := make([]balancer.SplitVariant, 0, len(sc.Variants))
+		for _, vc := range sc.Variants {
+			if !known[vc.Pool] {
+				return nil, fmt.Errorf("split %s: unknown pool %q", sc.Name, vc.Pool)
+			}
+			variants = append(variants, balancer.SplitVariant{Pool: vc.Pool, Weight: vc.Weight})
+		}
+		split, err := balancer.NewSplit(sc.Name, sc.Pool, variants, sc.Consistent)
+		if err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+	return splits, nil
+}
+
+// newSniffer builds a protocol sniffer from its configuration
+func newSniffer(sc *config.SniffingConfig) (*sniff.Sniffer, error) {
+	rules := make([]sniff.Rule, 0, len(sc.Rules))
+	for _, rc := range sc.Rules {
+		rule := sniff.Rule{Pool: rc.Pool}
+		switch {
+		case rc.Protocol != "":
+			protocol, err := sniff.ParseProtocol(rc.Protocol)
+			if err != nil {
+				return nil, err
+			}
+			rule.Protocol = protocol
+		case rc.Prefix != "":
+			rule.Prefix = []byte(rc.Prefix)
+		case rc.Regex != "":
+			re, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rule for pool %s: %w", rc.Pool, err)
+			}
+			rule.Regexp = re
+		default:
+			return nil, fmt.Errorf("rule for pool %s needs a protocol, prefix or regex", rc.Pool)
+		}
+		rules = append(rules, rule)
+	}
+
+	defaultPool := sc.DefaultPool
+	if defaultPool == "" {
+		defaultPool = balancer.DefaultPoolName
+	}
+	return sniff.NewSniffer(rules, defaultPool, sc.PeekTimeout), nil
+}
+
+// newACL builds the listener ACL and starts watching its rules file
+func newACL(ac *config.ACLConfig) (*acl.ACL, error) {
+	list, err := acl.New(ac.Allow, ac.Deny, ac.File)
+	if err != nil {
+		return nil, err
+	}
+	if ac.File != "" {
+		interval := ac.ReloadInterval
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+		go list.Watch(interval)
+	}
+	return list, nil
+}
+
+// newLimiter builds the listener's connection limiter
+func newLimiter(lc *config.LimitsConfig) (*ratelimit.Limiter, error) {
+	cfg := ratelimit.Config{
+		Rate:           lc.ConnRate,
+		Burst:          lc.ConnBurst,
+		PrefixRate:     lc.PrefixConnRate,
+		PrefixBurst:    lc.PrefixConnBurst,
+		IPv4PrefixLen:  lc.IPv4PrefixLen,
+		IPv6PrefixLen:  lc.IPv6PrefixLen,
+		MaxPerSource:   lc.MaxPerSource,
+		MaxConnections: lc.MaxConnections,
+		TableSize:      lc.TableSize,
+	}
+	switch lc.OnLimit {
+	case "", "reject":
+	case "queue":
+		cfg.QueueTimeout = lc.QueueTimeout
+		if cfg.QueueTimeout <= 0 {
+			cfg.QueueTimeout = time.Second
+		}
+		cfg.QueueSize = lc.QueueSize
+	default:
+		return nil, fmt.Errorf("unknown on_limit behavior %q", lc.OnLimit)
+	}
+	return ratelimit.NewLimiter(cfg), nil
+}
+
+// newThrottle builds a pool's bandwidth limits
+func newThrottle(bc *config.BandwidthConfig) (*ratelimit.Throttle, error) {
+	limits := func(lc config.BandwidthLimitsConfig) (ratelimit.BandwidthLimits, error) {
+		for _, r := range []config.ByteRateConfig{lc.In, lc.Out} {
+			if r.Rate < 0 || r.Burst < 0 {
+				return ratelimit.BandwidthLimits{}, fmt.Errorf("bandwidth rate and burst must not be negative")
+			}
+		}
+		return ratelimit.BandwidthLimits{
+			In:  ratelimit.ByteRate{Rate: lc.In.Rate, Burst: lc.In.Burst},
+			Out: ratelimit.ByteRate{Rate: lc.Out.Rate, Burst: lc.Out.Burst},
+		}, nil
+	}
+
+	cfg := ratelimit.BandwidthConfig{TableSize: bc.TableSize}
+	var err error
+	if cfg.PerConnection, err = limits(bc.PerConnection); err != nil {
+		return nil, err
+	}
+	if cfg.PerClient, err = limits(bc.PerClient); err != nil {
+		return nil, err
+	}
+	if cfg.PerBackend, err = limits(bc.PerBackend); err != nil {
+		return nil, err
+	}
+	return ratelimit.NewThrottle(cfg), nil
+}
+
+// serveMetrics exposes the metrics endpoint until the process exits
+func serveMetrics(mc config.MetricsConfig) {
+	path := mc.Path
+	if path == "" {
+		path = "/metrics"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, metrics.Handler())
+
+	slog.Info("Serving metrics", "listen_address", mc.ListenAddress, "path", path)
+	if err := http.ListenAndServe(mc.ListenAddress, mux); err != nil {
+		slog.Error("Metrics server stopped", "error", err)
+	}
+}
+
+// serveAdmin runs the admin API until the process exits
+func serveAdmin(server *admin.Server, addr string) {
+	slog.Info("Serving admin API", "listen_address", addr)
+	if err := server.ListenAndServe(addr); err != nil {
+		slog.Error("Admin API stopped", "error", err)
+	}
+}
+
+// newAdminServer creates the admin API for the pools, persisting changes to
+// the configuration file when one is used
+func newAdminServer(cfg *config.Config, configPath string, pools []*admin.Pool, splits []*balancer.Split) *admin.Server {
+	server := admin.NewServer()
+	for _, pool := range pools {
+		server.AddPool(pool)
+	}
+	for _, split := range splits {
+		server.AddSplit(split)
+	}
+	if configPath != "" {
+		server.EnablePersistence(configPath, cfg, cfg.Admin.Persist)
+	}
+	return server
+}
 
-	log.Println("Load balancer is running...")
+// backendsFromManager converts the managed servers into balancer backends
+func backendsFromManager(manager *backend.Manager) []balancer.Backend {
+	servers := manager.GetAllServers()
+	backends := make([]balancer.Backend, 0, len(servers))
+	for _, server := range servers {
+		st := server.Status()
+		backends = append(backends, balancer.Backend{
+			Address:      server.GetAddress(),
+			Healthy:      st.Healthy,
+			TLSConfig:    server.TLSConfig,
+			Weight:       st.Weight,
+			Priority:     st.Priority,
+			Zone:         st.Zone,
+			Draining:     st.Draining,
+			HealthySince: st.HealthySince,
+		})
+	}
+	return backends
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"l4-load-balancer/internal/admin"
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/config"
+)
+
+// watchReload reloads the configuration file whenever the process receives
+// SIGHUP. current is the configuration the process was started with.
+func watchReload(path string, current *config.Config, pools []*admin.Pool, splits []*balancer.Split, adminServer *admin.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		cfg, err := config.LoadConfig(path)
+		if err != nil {
+			slog.Error("Reload failed, keeping the current configuration", "error", err)
+			continue
+		}
+		// Hold the admin API's lock so its backend changes don't interleave
+		// with the reload's
+		err = adminServer.ReloadConfig(cfg, func() error {
+			return reload(cfg, current, pools, splits)
+		})
+		if err != nil {
+			slog.Error("Reload failed", "error", err)
+			continue
+		}
+		current = cfg
+		slog.Info("Reloaded configuration", "path", path)
+	}
+}
+
+// reload applies the backends and affinity settings of cfg to the running
+// pools and the split weights to the running splits. Other settings, and
+// the TLS settings of backends that stay configured, only take effect after
+// a restart. previous is the configuration being replaced.
+func reload(cfg, previous *config.Config, pools []*admin.Pool, splits []*balancer.Split) error {
+	configs := poolConfigs(cfg)
+	previousConfigs := poolConfigs(previous)
+
+	// Validate everything before changing anything
+	for _, pc := range configs {
+		for _, bc := range pc.Backends {
+			if err := backend.CheckAddress(backend.JoinAddress(bc.Address, bc.Port)); err != nil {
+				return fmt.Errorf("backend: %w", err)
+			}
+			if _, err := bc.TLS.ClientConfig(); err != nil {
+				return fmt.Errorf("TLS settings for backend %s:%d: %w", bc.Address, bc.Port, err)
+			}
+		}
+	}
+
+	weights := make(map[string]map[string]int, len(cfg.LoadBalancer.Splits))
+	for _, sc := range cfg.LoadBalancer.Splits {
+		weights[sc.Name] = make(map[string]int, len(sc.Variants))
+		for _, vc := range sc.Variants {
+			weights[sc.Name][vc.Pool] = vc.Weight
+		}
+	}
+	for _, split := range splits {
+		if w, ok := weights[split.Name]; ok {
+			if err := split.CheckWeights(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, split := range splits {
+		if w, ok := weights[split.Name]; ok {
+			split.SetWeights(w)
+		}
+	}
+
+	for _, pool := range pools {
+		pc, ok := configs[pool.Name]
+		if !ok {
+			slog.Warn("Pool was removed from the configuration; restart to remove it", "pool", pool.Name)
+			continue
+		}
+		delete(configs, pool.Name)
+
+		syncManager(pool, pc.Backends, previousConfigs[pool.Name].Backends)
+		reloadAffinity(pool, pc.Affinity)
+	}
+	for name := range configs {
+		slog.Warn("Pool was added to the configuration; restart to add it", "pool", name)
+	}
+	return nil
+}
+
+// poolConfigs returns the configuration of every pool in cfg by name,
+// including the default pool
+func poolConfigs(cfg *config.Config) map[string]config.PoolConfig {
+	configs := map[string]config.PoolConfig{
+		balancer.DefaultPoolName: {
+			Name:     balancer.DefaultPoolName,
+			Backends: cfg.Backends,
+			Affinity: cfg.LoadBalancer.Affinity,
+		},
+	}
+	for _, pc := range cfg.Pools {
+		configs[pc.Name] = pc
+	}
+	return configs
+}
+
+// syncManager adds, removes and reweights the pool's servers to match the
+// configured backends. A server's TLS settings are fixed when it is added,
+// so a change to them for a backend that stays is only logged; previous
+// holds the backends as last loaded to detect it.
+func syncManager(pool *admin.Pool, backends, previous []config.BackendConfig) {
+	wanted := make(map[string]config.BackendConfig, len(backends))
+	for _, bc := range backends {
+		wanted[backend.JoinAddress(bc.Address, bc.Port)] = bc
+	}
+	loaded := make(map[string]config.BackendConfig, len(previous))
+	for _, bc := range previous {
+		loaded[backend.JoinAddress(bc.Address, bc.Port)] = bc
+	}
+
+	for _, server := range pool.Manager.GetAllServers() {
+		address := server.GetAddress()
+		bc, ok := wanted[address]
+		if !ok {
+			pool.Manager.RemoveServer(address)
+			slog.Info("Reload: removed backend", "pool", pool.Name, "backend", address)
+			continue
+		}
+		delete(wanted, address)
+		if weight := max(bc.Weight, 1); weight != server.GetWeight() {
+			server.SetWeight(weight)
+			slog.Info("Reload: set backend weight", "pool", pool.Name, "backend", address, "weight", weight)
+		}
+		if bc.Priority != server.GetPriority() {
+			server.SetPriority(bc.Priority)
+			slog.Info("Reload: set backend priority", "pool", pool.Name, "backend", address, "priority", bc.Priority)
+		}
+		if bc.Zone != server.Status().Zone {
+			server.SetZone(bc.Zone)
+			slog.Info("Reload: set backend zone", "pool", pool.Name, "backend", address, "zone", bc.Zone)
+		}
+		if prev, ok := loaded[address]; ok && !reflect.DeepEqual(prev.TLS, bc.TLS) {
+			slog.Warn("Reload: TLS settings of an existing backend changed; restart or remove and re-add the backend to apply them", "pool", pool.Name, "backend", address)
+		}
+	}
+
+	added := make([]*backend.Server, 0, len(wanted))
+	for address, bc := range wanted {
+		tlsConfig, _ := bc.TLS.ClientConfig()
+		server := pool.Manager.AddServerWithOptions(bc.Address, bc.Port, backend.ServerOptions{
+			Weight:    bc.Weight,
+			Priority:  bc.Priority,
+			Zone:      bc.Zone,
+			TLSConfig: tlsConfig,
+		})
+		added = append(added, server)
+		slog.Info("Reload: added backend", "pool", pool.Name, "backend", address)
+	}
+	if pool.Checker != nil {
+		for _, server := range added {
+			go pool.Checker.CheckServer(server)
+		}
+	}
+}
+
+// reloadAffinity applies new affinity limits while keeping the remembered
+// clients
+func reloadAffinity(pool *admin.Pool, ac *config.AffinityConfig) {
+	table := pool.Balancer.Affinity()
+	switch {
+	case table != nil && ac != nil:
+		table.Configure(ac.TTL, ac.Size)
+	case table == nil && ac != nil, table != nil && ac == nil:
+		slog.Warn("Enabling or disabling affinity requires a restart", "pool", pool.Name)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"l4-load-balancer/internal/config"
+	"l4-load-balancer/internal/tracing"
+)
+
+// tracerShutdownTimeout bounds how long queued spans are flushed for when
+// the load balancer stops
+const tracerShutdownTimeout = 5 * time.Second
+
+// newTracer creates the configured tracer, or returns nil if tracing is off
+func newTracer(tc *config.TracingConfig) (*tracing.Tracer, error) {
+	if tc == nil {
+		return nil, nil
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch tc.Exporter {
+	case "otlp":
+		exporter, err = tracing.NewOTLPExporter(context.Background(), tc.Endpoint, tc.Headers)
+	case "stdout":
+		exporter, err = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		if tc.File == "" {
+			return nil, fmt.Errorf("tracing exporter %q needs a file", tc.Exporter)
+		}
+		exporter, err = tracing.NewFileExporter(tc.File)
+	default:
+		return nil, fmt.Errorf(`unknown tracing exporter %q, expected "otlp", "stdout" or "file"`, tc.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Export failures are reported through the global handler, which
+	// otherwise writes to the standard logger
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Failed to export spans", "error", err)
+	}))
+	return tracing.NewTracer(exporter, tracing.Options{
+		ServiceName:   tc.ServiceName,
+		SamplePercent: tc.SamplePercent,
+	}), nil
+}
+
+// shutdownTracer flushes the spans still queued, giving up after
+// tracerShutdownTimeout
+func shutdownTracer(tracer *tracing.Tracer) {
+	ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+}
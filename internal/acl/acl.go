@@ -0,0 +1,242 @@
+package acl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"l4-load-balancer/pkg/netutil"
+)
+
+// rules is an immutable set of allow and deny prefixes
+type rules struct {
+	allow    *trie
+	deny     *trie
+	hasAllow bool
+}
+
+// ACL decides whether a client address may connect. A matching deny entry
+// always rejects; when any allow entries exist the address must match one.
+type ACL struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	file  string
+
+	rules    atomic.Pointer[rules]
+	accepted atomic.Uint64
+	rejected atomic.Uint64
+
+	mu       sync.Mutex
+	fileMod  time.Time
+	fileSize int64
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates an ACL from allow and deny CIDRs plus an optional rules file.
+// Bare IP addresses are treated as single-host prefixes.
+func New(allow, deny []string, file string) (*ACL, error) {
+	a := &ACL{
+		file:   file,
+		stopCh: make(chan struct{}),
+	}
+
+	var err error
+	if a.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Allowed reports whether a connection from addr is permitted and updates
+// the accepted and rejected counters
+func (a *ACL) Allowed(addr net.Addr) bool {
+	ip := netutil.AddrIP(addr)
+	if !ip.IsValid() {
+		a.rejected.Add(1)
+		return false
+	}
+
+	r := a.rules.Load()
+	if r.deny.contains(ip) || (r.hasAllow && !r.allow.contains(ip)) {
+		a.rejected.Add(1)
+		return false
+	}
+	a.accepted.Add(1)
+	return true
+}
+
+// Accepted returns the number of connections allowed so far
+func (a *ACL) Accepted() uint64 {
+	return a.accepted.Load()
+}
+
+// Rejected returns the number of connections rejected so far
+func (a *ACL) Rejected() uint64 {
+	return a.rejected.Load()
+}
+
+// Reload rebuilds the rules from the static lists and the rules file. On
+// error the previous rules stay in effect.
+func (a *ACL) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	allow := append([]netip.Prefix(nil), a.allow...)
+	deny := append([]netip.Prefix(nil), a.deny...)
+
+	if a.file != "" {
+		info, err := os.Stat(a.file)
+		if err != nil {
+			return err
+		}
+		fileAllow, fileDeny, err := loadFile(a.file)
+		if err != nil {
+			return err
+		}
+		allow = append(allow, fileAllow...)
+		deny = append(deny, fileDeny...)
+		a.fileMod = info.ModTime()
+		a.fileSize = info.Size()
+	}
+
+	r := &rules{allow: newTrie(), deny: newTrie(), hasAllow: len(allow) > 0}
+	for _, p := range allow {
+		r.allow.insert(p)
+	}
+	for _, p := range deny {
+		r.deny.insert(p)
+	}
+	a.rules.Store(r)
+	return nil
+}
+
+// Watch polls the rules file and reloads it whenever it changes, until Stop
+// is called
+func (a *ACL) Watch(interval time.Duration) {
+	if a.file == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := a.fileChanged()
+			if err != nil {
+				slog.Warn("Failed to stat ACL file", "file", a.file, "error", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := a.Reload(); err != nil {
+				slog.Warn("Failed to reload ACL file", "file", a.file, "error", err)
+				continue
+			}
+			slog.Info("Reloaded ACL file", "file", a.file)
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+// fileChanged reports whether the rules file differs from the loaded one
+func (a *ACL) fileChanged() (bool, error) {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !info.ModTime().Equal(a.fileMod) || info.Size() != a.fileSize, nil
+}
+
+// Stop stops watching the rules file
+func (a *ACL) Stop() {
+	a.stopOnce.Do(func() { close(a.stopCh) })
+}
+
+// loadFile reads a rules file. Each line is "allow <cidr>" or "deny <cidr>";
+// blank lines and lines starting with # are ignored.
+func loadFile(path string) (allow, deny []netip.Prefix, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: expected \"allow|deny <cidr>\"", path, lineNo)
+		}
+		prefix, err := parsePrefix(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, prefix)
+		case "deny":
+			deny = append(deny, prefix)
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: unknown action %q", path, lineNo, fields[0])
+		}
+	}
+	return allow, deny, scanner.Err()
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		p, err := parsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses a CIDR or a bare IP address
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		p, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96), nil
+		}
+		return p, nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
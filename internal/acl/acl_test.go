@@ -0,0 +1,132 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+// replaceFile swaps the file contents atomically so the watcher never sees
+// a partially written file
+func replaceFile(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACL_Allowed(t *testing.T) {
+	list, err := New(
+		[]string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.7"},
+		[]string{"10.1.0.0/16", "2001:db8:bad::/48"},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.2.3", false},   // denied subnet inside allowed range
+		{"11.0.0.1", false},   // not in any allow entry
+		{"192.168.1.7", true}, // bare IP entry
+		{"192.168.1.8", false},
+		{"2001:db8::1", true},
+		{"2001:db8:bad::1", false},
+		{"2001:db9::1", false},
+		{"::ffff:10.2.3.4", true}, // IPv4-mapped IPv6
+	}
+
+	for _, tt := range tests {
+		if got := list.Allowed(tcpAddr(tt.ip)); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, expected %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	if list.Rejected() != 5 || list.Accepted() != 4 {
+		t.Errorf("Expected 4 accepted and 5 rejected, got %d and %d", list.Accepted(), list.Rejected())
+	}
+}
+
+func TestACL_DenyOnly(t *testing.T) {
+	list, err := New(nil, []string{"0.0.0.0/0"}, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if list.Allowed(tcpAddr("1.2.3.4")) {
+		t.Error("Expected all IPv4 clients to be denied")
+	}
+	if !list.Allowed(tcpAddr("2001:db8::1")) {
+		t.Error("Expected IPv6 clients to be allowed")
+	}
+}
+
+func TestACL_FileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.txt")
+	replaceFile(t, path, "# office\nallow 10.0.0.0/8\n")
+
+	list, err := New(nil, nil, path)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	go list.Watch(10 * time.Millisecond)
+	defer list.Stop()
+
+	if !list.Allowed(tcpAddr("10.0.0.1")) {
+		t.Fatal("Expected address from rules file to be allowed")
+	}
+
+	replaceFile(t, path, "allow 10.0.0.0/8\ndeny 10.0.0.0/24\n")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for list.Allowed(tcpAddr("10.0.0.1")) {
+		if time.Now().After(deadline) {
+			t.Fatal("Rules file change was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken file keeps the previous rules
+	replaceFile(t, path, "permit everything\n")
+	if err := list.Reload(); err == nil {
+		t.Error("Expected reload of invalid file to fail")
+	}
+	if list.Allowed(tcpAddr("10.0.0.1")) || !list.Allowed(tcpAddr("10.0.1.1")) {
+		t.Error("Expected previous rules to remain in effect")
+	}
+
+	// Stopping twice is harmless
+	list.Stop()
+}
+
+func BenchmarkACL_Allowed(b *testing.B) {
+	deny := make([]string, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		deny = append(deny, fmt.Sprintf("%d.%d.%d.0/24", 1+i/65536, (i/256)%256, i%256))
+	}
+	list, err := New(nil, deny, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := tcpAddr("200.1.2.3")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Allowed(addr)
+	}
+}
@@ -0,0 +1,78 @@
+package acl
+
+import (
+	"net/netip"
+)
+
+// trie is a binary prefix trie holding IPv4 and IPv6 prefixes. Lookups take
+// at most 32 or 128 steps regardless of how many prefixes are stored.
+type trie struct {
+	v4 *node
+	v6 *node
+}
+
+type node struct {
+	children [2]*node
+	terminal bool
+}
+
+func newTrie() *trie {
+	return &trie{v4: &node{}, v6: &node{}}
+}
+
+// insert adds a prefix to the trie
+func (t *trie) insert(prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	root, bytes := t.root(addr)
+
+	n := root
+	for i := 0; i < prefix.Bits(); i++ {
+		b := bit(bytes, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+		if n.terminal {
+			// A shorter prefix already covers this one
+			return
+		}
+	}
+	n.terminal = true
+	n.children = [2]*node{}
+}
+
+// contains reports whether any stored prefix contains addr
+func (t *trie) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	root, bytes := t.root(addr)
+
+	n := root
+	if n.terminal {
+		return true
+	}
+	for i := 0; i < addr.BitLen(); i++ {
+		n = n.children[bit(bytes, i)]
+		if n == nil {
+			return false
+		}
+		if n.terminal {
+			return true
+		}
+	}
+	return false
+}
+
+// root returns the root node for the address family and the address bytes
+func (t *trie) root(addr netip.Addr) (*node, []byte) {
+	if addr.Is4() {
+		b := addr.As4()
+		return t.v4, b[:]
+	}
+	b := addr.As16()
+	return t.v6, b[:]
+}
+
+func bit(bytes []byte, i int) int {
+	return int(bytes[i/8]>>(7-uint(i%8))) & 1
+}
@@ -0,0 +1,754 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/config"
+	"l4-load-balancer/internal/health"
+	"l4-load-balancer/pkg/pool"
+)
+
+// Pool is a backend pool the admin API can inspect and modify
+type Pool struct {
+	Name     string
+	Manager  *backend.Manager
+	Checker  *health.Checker
+	Balancer *balancer.Pool
+
+	// ConnectionPools holds the pool's per-backend connection pools, if
+	// connection pooling is enabled
+	ConnectionPools *backend.PoolRegistry
+}
+
+// Server serves the admin API
+type Server struct {
+	mu     sync.Mutex
+	pools  map[string]*Pool
+	order  []string
+	splits map[string]*balancer.Split
+	store  *store
+	mux    *http.ServeMux
+}
+
+// BackendStatus describes a backend in API responses
+type BackendStatus struct {
+	Address           string      `json:"address"`
+	Healthy           bool        `json:"healthy"`
+	Weight            int         `json:"weight"`
+	Priority          int         `json:"priority"`
+	Zone              string      `json:"zone,omitempty"`
+	Forced            string      `json:"forced,omitempty"`
+	Draining          bool        `json:"draining"`
+	ActiveConnections int64       `json:"active_connections"`
+	CircuitBreaker    string      `json:"circuit_breaker,omitempty"`
+	ConnectionPool    *pool.Stats `json:"connection_pool,omitempty"`
+	HealthySince      time.Time   `json:"healthy_since"`
+	LastChecked       time.Time   `json:"last_checked"`
+	LastCheckPassed   bool        `json:"last_check_passed"`
+}
+
+// PoolStatus describes a pool in API responses
+type PoolStatus struct {
+	Name     string          `json:"name"`
+	Backends []BackendStatus `json:"backends"`
+}
+
+// DrainResult describes the outcome of draining a backend
+type DrainResult struct {
+	Address   string `json:"address"`
+	Drained   bool   `json:"drained"`
+	Remaining int64  `json:"remaining_connections"`
+	Closed    int    `json:"closed_connections"`
+}
+
+// DefaultDrainTimeout is how long a drain request waits for connections to
+// finish when the request doesn't say
+const DefaultDrainTimeout = 30 * time.Second
+
+// drainPollInterval is how often a drain request rechecks connection counts
+const drainPollInterval = 100 * time.Millisecond
+
+// forceCloseTimeout is how long a forced drain waits for the connections it
+// closed to finish
+const forceCloseTimeout = 2 * time.Second
+
+// NewServer creates an admin API server with no pools
+func NewServer() *Server {
+	s := &Server{
+		pools:  make(map[string]*Pool),
+		splits: make(map[string]*balancer.Split),
+		mux:    http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+// AddPool makes a pool available through the API
+func (s *Server) AddPool(pool *Pool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.pools[pool.Name]; !exists {
+		s.order = append(s.order, pool.Name)
+	}
+	s.pools[pool.Name] = pool
+}
+
+// AddSplit makes a traffic split available through the API
+func (s *Server) AddSplit(split *balancer.Split) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.splits[split.Name] = split
+}
+
+// EnablePersistence makes changes be written back to the configuration file.
+// With always set, every change is persisted; otherwise only requests with
+// ?persist=true are.
+func (s *Server) EnablePersistence(path string, cfg *config.Config, always bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = newStore(path, cfg, always)
+}
+
+// ReloadConfig runs apply, which applies a reloaded configuration file to
+// the running pools, holding the lock the API's backend changes take so the
+// two don't interleave. If apply succeeds, cfg becomes the configuration
+// that changes are persisted into.
+func (s *Server) ReloadConfig(cfg *config.Config, apply func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := apply(); err != nil {
+		return err
+	}
+	if s.store != nil {
+		s.store = newStore(s.store.path, cfg, cfg.Admin.Persist)
+	}
+	return nil
+}
+
+// Handler returns the API's HTTP handler
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves the API on addr
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.mux)
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /pools", s.listPools)
+	s.mux.HandleFunc("GET /pools/{pool}", s.getPool)
+	s.mux.HandleFunc("POST /pools/{pool}/backends", s.addBackend)
+	s.mux.HandleFunc("DELETE /pools/{pool}/backends/{backend}", s.removeBackend)
+	s.mux.HandleFunc("PUT /pools/{pool}/backends/{backend}/weight", s.setWeight)
+	s.mux.HandleFunc("PUT /pools/{pool}/backends/{backend}/state", s.setState)
+	s.mux.HandleFunc("POST /pools/{pool}/backends/{backend}/drain", s.drainBackend)
+	s.mux.HandleFunc("DELETE /pools/{pool}/backends/{backend}/drain", s.undrainBackend)
+	s.mux.HandleFunc("DELETE /pools/{pool}/backends/{backend}/circuit_breaker", s.resetBreaker)
+	s.mux.HandleFunc("GET /pools/{pool}/affinity", s.listAffinity)
+	s.mux.HandleFunc("DELETE /pools/{pool}/affinity", s.clearAffinity)
+	s.mux.HandleFunc("DELETE /pools/{pool}/affinity/{client}", s.deleteAffinity)
+	s.mux.HandleFunc("POST /pools/{pool}/healthcheck", s.checkPool)
+	s.mux.HandleFunc("GET /splits", s.listSplits)
+	s.mux.HandleFunc("GET /splits/{split}", s.getSplit)
+	s.mux.HandleFunc("PUT /splits/{split}/weights", s.setSplitWeights)
+	s.mux.HandleFunc("POST /pools/{pool}/backends/{backend}/healthcheck", s.checkBackend)
+	s.mux.HandleFunc("GET /connections", s.listConnections)
+	s.mux.HandleFunc("DELETE /connections", s.closeConnections)
+	s.mux.HandleFunc("DELETE /connections/{id}", s.closeConnection)
+}
+
+func (s *Server) listPools(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statuses := make([]PoolStatus, 0, len(s.order))
+	for _, name := range s.order {
+		statuses = append(statuses, poolStatus(s.pools[name]))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{"pools": statuses})
+}
+
+func (s *Server) getPool(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, poolStatus(pool))
+}
+
+func (s *Server) addBackend(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+
+	var bc config.BackendConfig
+	if err := json.NewDecoder(r.Body).Decode(&bc); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	address := backendKey(bc)
+	if bc.Address == "" || backend.CheckAddress(address) != nil {
+		writeError(w, http.StatusBadRequest, errors.New("address and a valid port, or a unix:// address, are required"))
+		return
+	}
+	tlsConfig, err := bc.TLS.ClientConfig()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	if pool.Manager.GetServer(address) != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("backend %s already exists", address))
+		return
+	}
+	server := pool.Manager.AddServerWithOptions(bc.Address, bc.Port, backend.ServerOptions{
+		Weight:    bc.Weight,
+		Priority:  bc.Priority,
+		Zone:      bc.Zone,
+		TLSConfig: tlsConfig,
+	})
+	err = s.persist(r, pool.Name, func(st *store) { st.add(pool.Name, bc) })
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	slog.Info("Admin: added backend", "pool", pool.Name, "backend", address)
+	if pool.Checker != nil {
+		pool.Checker.CheckServer(server)
+	}
+	writeJSON(w, http.StatusCreated, backendStatus(pool, server))
+}
+
+func (s *Server) removeBackend(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	address := r.PathValue("backend")
+
+	s.mu.Lock()
+	server := pool.Manager.RemoveServer(address)
+	var err error
+	if server != nil {
+		err = s.persist(r, pool.Name, func(st *store) { st.remove(pool.Name, address) })
+	}
+	s.mu.Unlock()
+
+	if server == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("backend %s not found in pool %s", address, pool.Name))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	slog.Info("Admin: removed backend", "pool", pool.Name, "backend", address)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setWeight(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Weight int `json:"weight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Weight < 1 {
+		writeError(w, http.StatusBadRequest, errors.New(`expected {"weight": n} with n >= 1`))
+		return
+	}
+
+	s.mu.Lock()
+	server.SetWeight(req.Weight)
+	err := s.persist(r, pool.Name, nil)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	slog.Info("Admin: set backend weight", "pool", pool.Name, "backend", server.GetAddress(), "weight", req.Weight)
+	writeJSON(w, http.StatusOK, backendStatus(pool, server))
+}
+
+func (s *Server) setState(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	var state backend.ForcedState
+	switch req.State {
+	case "up":
+		state = backend.ForceUp
+	case "down":
+		state = backend.ForceDown
+	case "auto", "":
+		state = backend.ForceNone
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf(`unknown state %q, expected "up", "down" or "auto"`, req.State))
+		return
+	}
+
+	server.SetForcedState(state)
+	slog.Info("Admin: set backend state", "pool", pool.Name, "backend", server.GetAddress(), "state", req.State)
+	writeJSON(w, http.StatusOK, backendStatus(pool, server))
+}
+
+// drainBackend stops new connections to a backend and waits up to the
+// requested timeout for its existing connections to finish. With force set,
+// connections still open at the deadline are closed.
+func (s *Server) drainBackend(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Timeout string `json:"timeout"`
+		Force   bool   `json:"force"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+	}
+	timeout := DefaultDrainTimeout
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", req.Timeout))
+			return
+		}
+		timeout = d
+	}
+
+	address := server.GetAddress()
+	if server.SetDraining(true) {
+		slog.Info("Admin: draining backend", "pool", pool.Name, "backend", address)
+	}
+
+	result := DrainResult{Address: address}
+	if pool.Balancer != nil {
+		result.Remaining = waitDrained(r, pool.Balancer, address, timeout)
+		if result.Remaining > 0 && req.Force {
+			result.Closed = pool.Balancer.CloseConnections(address)
+			// Closed sessions are untracked once their proxy loops return
+			result.Remaining = waitDrained(r, pool.Balancer, address, forceCloseTimeout)
+			slog.Info("Admin: closed remaining connections", "pool", pool.Name, "backend", address, "connections", result.Closed)
+		}
+	}
+	result.Drained = result.Remaining == 0
+	writeJSON(w, http.StatusOK, result)
+}
+
+// waitDrained polls until the backend has no active connections, the
+// timeout passes or the request is cancelled, and returns the remaining
+// connection count
+func waitDrained(r *http.Request, pool *balancer.Pool, address string, timeout time.Duration) int64 {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		remaining := pool.ActiveConnections(address)
+		if remaining == 0 {
+			return 0
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return pool.ActiveConnections(address)
+		case <-r.Context().Done():
+			return pool.ActiveConnections(address)
+		}
+	}
+}
+
+// undrainBackend lets a draining backend receive new connections again
+func (s *Server) undrainBackend(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+	if server.SetDraining(false) {
+		slog.Info("Admin: stopped draining backend", "pool", pool.Name, "backend", server.GetAddress())
+	}
+	writeJSON(w, http.StatusOK, backendStatus(pool, server))
+}
+
+// resetBreaker closes a backend's circuit breaker
+func (s *Server) resetBreaker(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+	if pool.Balancer == nil || !pool.Balancer.ResetBreaker(server.GetAddress()) {
+		writeError(w, http.StatusConflict, fmt.Errorf("pool %s has no circuit breakers", pool.Name))
+		return
+	}
+	slog.Info("Admin: reset circuit breaker", "pool", pool.Name, "backend", server.GetAddress())
+	writeJSON(w, http.StatusOK, backendStatus(pool, server))
+}
+
+func (s *Server) listAffinity(w http.ResponseWriter, r *http.Request) {
+	pool, table, ok := s.lookupAffinity(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pool":    pool.Name,
+		"entries": table.Entries(time.Now()),
+	})
+}
+
+func (s *Server) clearAffinity(w http.ResponseWriter, r *http.Request) {
+	pool, table, ok := s.lookupAffinity(w, r)
+	if !ok {
+		return
+	}
+	table.Clear()
+	slog.Info("Admin: cleared affinity table", "pool", pool.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteAffinity(w http.ResponseWriter, r *http.Request) {
+	pool, table, ok := s.lookupAffinity(w, r)
+	if !ok {
+		return
+	}
+	client, err := netip.ParseAddr(r.PathValue("client"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid client IP %q", r.PathValue("client")))
+		return
+	}
+	table.Delete(client.Unmap())
+	slog.Info("Admin: removed client from affinity table", "pool", pool.Name, "client", client)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) lookupAffinity(w http.ResponseWriter, r *http.Request) (*Pool, *balancer.AffinityTable, bool) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	var table *balancer.AffinityTable
+	if pool.Balancer != nil {
+		table = pool.Balancer.Affinity()
+	}
+	if table == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("pool %s has no affinity table", pool.Name))
+		return nil, nil, false
+	}
+	return pool, table, true
+}
+
+func (s *Server) checkPool(w http.ResponseWriter, r *http.Request) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return
+	}
+	if pool.Checker == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("pool %s has no health checker", pool.Name))
+		return
+	}
+	pool.Checker.CheckNow()
+	writeJSON(w, http.StatusOK, poolStatus(pool))
+}
+
+func (s *Server) checkBackend(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := s.lookupBackend(w, r)
+	if !ok {
+		return
+	}
+	if pool.Checker == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("pool %s has no health checker", pool.Name))
+		return
+	}
+	pool.Checker.CheckServer(server)
+	writeJSON(w, http.StatusOK, backendStatus(pool, server))
+}
+
+func (s *Server) listSplits(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stats := make([]balancer.SplitStats, 0, len(s.splits))
+	for _, split := range s.splits {
+		stats = append(stats, split.Stats())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"splits": stats})
+}
+
+func (s *Server) getSplit(w http.ResponseWriter, r *http.Request) {
+	split, ok := s.lookupSplit(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, split.Stats())
+}
+
+func (s *Server) setSplitWeights(w http.ResponseWriter, r *http.Request) {
+	split, ok := s.lookupSplit(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Weights map[string]int `json:"weights"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := split.SetWeights(req.Weights); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	slog.Info("Admin: set split weights", "split", split.Name, "weights", req.Weights)
+	writeJSON(w, http.StatusOK, split.Stats())
+}
+
+func (s *Server) lookupSplit(w http.ResponseWriter, r *http.Request) (*balancer.Split, bool) {
+	name := r.PathValue("split")
+	s.mu.Lock()
+	split, ok := s.splits[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("split %s not found", name))
+	}
+	return split, ok
+}
+
+// listConnections lists active proxied connections, optionally filtered by
+// pool, backend and client CIDR
+func (s *Server) listConnections(w http.ResponseWriter, r *http.Request) {
+	pools, filter, ok := s.connectionFilter(w, r)
+	if !ok {
+		return
+	}
+	connections := []balancer.ConnectionInfo{}
+	for _, pool := range pools {
+		connections = append(connections, pool.Balancer.Connections(filter)...)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ID < connections[j].ID })
+	writeJSON(w, http.StatusOK, map[string]any{"connections": connections})
+}
+
+// closeConnections terminates every active connection matching the filters.
+// At least one filter is required so that a bare request can't close
+// everything.
+func (s *Server) closeConnections(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("pool") == "" && query.Get("backend") == "" && query.Get("client") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("at least one of pool, backend or client is required"))
+		return
+	}
+	pools, filter, ok := s.connectionFilter(w, r)
+	if !ok {
+		return
+	}
+	closed := 0
+	for _, pool := range pools {
+		closed += pool.Balancer.CloseMatching(filter)
+	}
+	slog.Info("Admin: closed connections", "pool", query.Get("pool"), "backend", filter.Backend, "client", query.Get("client"), "connections", closed)
+	writeJSON(w, http.StatusOK, map[string]int{"closed": closed})
+}
+
+// closeConnection terminates one active connection by ID
+func (s *Server) closeConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid connection ID %q", r.PathValue("id")))
+		return
+	}
+	for _, pool := range s.balancerPools() {
+		if pool.Balancer.CloseConnection(id) {
+			slog.Info("Admin: closed connection", "pool", pool.Name, "id", id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("connection %d not found", id))
+}
+
+// connectionFilter parses the pool, backend and client query parameters of
+// a connection request
+func (s *Server) connectionFilter(w http.ResponseWriter, r *http.Request) ([]*Pool, balancer.ConnectionFilter, bool) {
+	query := r.URL.Query()
+	filter := balancer.ConnectionFilter{Backend: query.Get("backend")}
+	if v := query.Get("client"); v != "" {
+		prefix, err := parseClient(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return nil, filter, false
+		}
+		filter.Client = prefix
+	}
+
+	pools := s.balancerPools()
+	if name := query.Get("pool"); name != "" {
+		var selected []*Pool
+		for _, pool := range pools {
+			if pool.Name == name {
+				selected = append(selected, pool)
+			}
+		}
+		if selected == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("pool %s not found", name))
+			return nil, filter, false
+		}
+		pools = selected
+	}
+	return pools, filter, true
+}
+
+// parseClient parses a client CIDR; a bare IP selects that single address
+func parseClient(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid client %q, expected an IP or CIDR", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// balancerPools returns the pools that proxy connections, in the order
+// they were added
+func (s *Server) balancerPools() []*Pool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pools := make([]*Pool, 0, len(s.order))
+	for _, name := range s.order {
+		if pool := s.pools[name]; pool.Balancer != nil {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// persist applies change to the stored configuration and writes it out if
+// persistence applies to this request. Callers hold s.mu.
+func (s *Server) persist(r *http.Request, poolName string, change func(*store)) error {
+	if s.store == nil {
+		return nil
+	}
+	if change != nil {
+		change(s.store)
+	}
+
+	enabled := s.store.always
+	if v := r.URL.Query().Get("persist"); v != "" {
+		enabled, _ = strconv.ParseBool(v)
+	}
+	if !enabled {
+		return nil
+	}
+	return s.store.save(s.pools)
+}
+
+func (s *Server) lookupPool(w http.ResponseWriter, r *http.Request) (*Pool, bool) {
+	name := r.PathValue("pool")
+	s.mu.Lock()
+	pool, ok := s.pools[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("pool %s not found", name))
+	}
+	return pool, ok
+}
+
+func (s *Server) lookupBackend(w http.ResponseWriter, r *http.Request) (*Pool, *backend.Server, bool) {
+	pool, ok := s.lookupPool(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	address := r.PathValue("backend")
+	server := pool.Manager.GetServer(address)
+	if server == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("backend %s not found in pool %s", address, pool.Name))
+		return nil, nil, false
+	}
+	return pool, server, true
+}
+
+func poolStatus(pool *Pool) PoolStatus {
+	servers := pool.Manager.GetAllServers()
+	status := PoolStatus{Name: pool.Name, Backends: make([]BackendStatus, 0, len(servers))}
+	for _, server := range servers {
+		status.Backends = append(status.Backends, backendStatus(pool, server))
+	}
+	return status
+}
+
+func backendStatus(pool *Pool, server *backend.Server) BackendStatus {
+	st := server.Status()
+	status := BackendStatus{
+		Address:         server.GetAddress(),
+		Healthy:         st.Healthy,
+		Weight:          server.GetWeight(),
+		Priority:        st.Priority,
+		Zone:            st.Zone,
+		Forced:          string(st.Forced),
+		Draining:        st.Draining,
+		HealthySince:    st.HealthySince,
+		LastChecked:     st.LastChecked,
+		LastCheckPassed: st.LastCheckPassed,
+	}
+	if pool.Balancer != nil {
+		status.ActiveConnections = pool.Balancer.ActiveConnections(status.Address)
+		status.CircuitBreaker = string(pool.Balancer.BreakerState(status.Address))
+	}
+	if pool.ConnectionPools != nil {
+		if cp := pool.ConnectionPools.Pool(status.Address); cp != nil {
+			stats := cp.Stats()
+			status.ConnectionPool = &stats
+		}
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,481 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/config"
+	"l4-load-balancer/internal/health"
+	"l4-load-balancer/internal/testutil"
+)
+
+// newTestPool returns a pool whose manager holds one reachable backend
+func newTestPool(t *testing.T) (*Pool, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	manager := backend.NewManager()
+	manager.AddServer("127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+	return &Pool{
+		Name:     "default",
+		Manager:  manager,
+		Checker:  health.NewChecker(manager, time.Hour, time.Second),
+		Balancer: balancer.NewStaticPool("default", balancer.NewRoundRobinAlgorithm(), nil),
+	}, ln.Addr().String()
+}
+
+func do(t *testing.T, handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestServer_BackendLifecycle(t *testing.T) {
+	pool, addr := newTestPool(t)
+	server := NewServer()
+	server.AddPool(pool)
+	h := server.Handler()
+
+	// Trigger a health check so the existing backend becomes healthy
+	rec := do(t, h, "POST", "/pools/default/healthcheck", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Health check failed: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(t, h, "GET", "/pools", "")
+	var list struct {
+		Pools []PoolStatus `json:"pools"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Pools) != 1 || len(list.Pools[0].Backends) != 1 {
+		t.Fatalf("Unexpected pools: %+v", list.Pools)
+	}
+	if b := list.Pools[0].Backends[0]; b.Address != addr || !b.Healthy || !b.LastCheckPassed || b.Weight != 1 {
+		t.Errorf("Unexpected backend status: %+v", b)
+	}
+
+	// Adding an unreachable backend checks it immediately
+	rec = do(t, h, "POST", "/pools/default/backends", `{"address": "127.0.0.1", "port": 1, "weight": 3}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Add failed: %d %s", rec.Code, rec.Body)
+	}
+	var added BackendStatus
+	json.Unmarshal(rec.Body.Bytes(), &added)
+	if added.Healthy || added.Weight != 3 || added.LastChecked.IsZero() {
+		t.Errorf("Unexpected added backend: %+v", added)
+	}
+	if rec := do(t, h, "POST", "/pools/default/backends", `{"address": "127.0.0.1", "port": 1}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected conflict for duplicate backend, got %d", rec.Code)
+	}
+
+	// Forcing the backend up overrides the failed check
+	rec = do(t, h, "PUT", "/pools/default/backends/127.0.0.1:1/state", `{"state": "up"}`)
+	if rec.Code != http.StatusOK || !pool.Manager.GetServer("127.0.0.1:1").IsHealthy() {
+		t.Errorf("Force up failed: %d %s", rec.Code, rec.Body)
+	}
+	do(t, h, "PUT", "/pools/default/backends/127.0.0.1:1/state", `{"state": "auto"}`)
+	if pool.Manager.GetServer("127.0.0.1:1").IsHealthy() {
+		t.Error("Expected backend to return to its checked state")
+	}
+
+	rec = do(t, h, "PUT", "/pools/default/backends/"+addr+"/weight", `{"weight": 5}`)
+	if rec.Code != http.StatusOK || pool.Manager.GetServer(addr).GetWeight() != 5 {
+		t.Errorf("Set weight failed: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "PUT", "/pools/default/backends/"+addr+"/weight", `{"weight": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for zero weight, got %d", rec.Code)
+	}
+
+	rec = do(t, h, "DELETE", "/pools/default/backends/127.0.0.1:1", "")
+	if rec.Code != http.StatusNoContent || len(pool.Manager.GetAllServers()) != 1 {
+		t.Errorf("Remove failed: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, h, "GET", "/pools/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown pool, got %d", rec.Code)
+	}
+	if rec := do(t, h, "DELETE", "/pools/default/backends/127.0.0.1:1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for removed backend, got %d", rec.Code)
+	}
+}
+
+func TestServer_Persistence(t *testing.T) {
+	pool, addr := newTestPool(t)
+	port := pool.Manager.GetServer(addr).Port
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := config.GetDefaultConfig()
+	cfg.Backends = []config.BackendConfig{
+		{Address: "127.0.0.1", Port: port, TLS: &config.TLSConfig{Enabled: true, ServerName: "api.internal"}},
+	}
+	if err := config.SaveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer()
+	server.AddPool(pool)
+	server.EnablePersistence(path, cfg, false)
+	h := server.Handler()
+
+	// Changes are only persisted when asked for
+	do(t, h, "POST", "/pools/default/backends", `{"address": "10.0.0.9", "port": 9000}`)
+	saved, _ := config.LoadConfig(path)
+	if len(saved.Backends) != 1 {
+		t.Fatalf("Expected unpersisted change, got %d backends", len(saved.Backends))
+	}
+
+	do(t, h, "PUT", fmt.Sprintf("/pools/default/backends/%s/weight?persist=true", addr), `{"weight": 4}`)
+	saved, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Backends) != 2 {
+		t.Fatalf("Expected 2 persisted backends, got %+v", saved.Backends)
+	}
+	first := saved.Backends[0]
+	if first.Weight != 4 || first.TLS == nil || first.TLS.ServerName != "api.internal" {
+		t.Errorf("Expected weight and TLS settings to be kept, got %+v", first)
+	}
+	if saved.HealthCheck.Interval != cfg.HealthCheck.Interval {
+		t.Errorf("Expected other settings to be kept, got %+v", saved.HealthCheck)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ReloadConfig(t *testing.T) {
+	pool, _ := newTestPool(t)
+	server := NewServer()
+	server.AddPool(pool)
+	h := server.Handler()
+
+	// A backend added through the API waits for the reload in progress, so
+	// the reload can't remove it between its check and its add
+	added := make(chan int)
+	err := server.ReloadConfig(config.GetDefaultConfig(), func() error {
+		go func() {
+			added <- do(t, h, "POST", "/pools/default/backends", `{"address": "127.0.0.1", "port": 1}`).Code
+		}()
+		select {
+		case <-added:
+			t.Error("Expected the add to wait for the reload")
+		case <-time.After(50 * time.Millisecond):
+		}
+		pool.Manager.AddServer("127.0.0.1", 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := <-added; code != http.StatusConflict {
+		t.Errorf("Expected the add to see the reloaded backend, got %d", code)
+	}
+
+	failed := errors.New("invalid backend")
+	if err := server.ReloadConfig(config.GetDefaultConfig(), func() error { return failed }); err != failed {
+		t.Errorf("Expected the reload error, got %v", err)
+	}
+}
+
+func TestServer_Drain(t *testing.T) {
+	pool, addr := newTestPool(t)
+	server := NewServer()
+	server.AddPool(pool)
+	h := server.Handler()
+
+	rec := do(t, h, "POST", "/pools/default/backends/"+addr+"/drain", `{"timeout": "1s", "force": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Drain failed: %d %s", rec.Code, rec.Body)
+	}
+	var result DrainResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if !result.Drained || result.Remaining != 0 || result.Closed != 0 {
+		t.Errorf("Unexpected drain result: %+v", result)
+	}
+	if !pool.Manager.GetServer(addr).IsDraining() {
+		t.Error("Expected backend to be draining")
+	}
+
+	if rec := do(t, h, "POST", "/pools/default/backends/"+addr+"/drain", `{"timeout": "soon"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid timeout, got %d", rec.Code)
+	}
+
+	rec = do(t, h, "DELETE", "/pools/default/backends/"+addr+"/drain", "")
+	var status BackendStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.Draining || pool.Manager.GetServer(addr).IsDraining() {
+		t.Errorf("Undrain failed: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServer_ForceDrain(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	addr := backendLn.Addr().String()
+	manager := backend.NewManager()
+	manager.AddServer("127.0.0.1", backendLn.Addr().(*net.TCPAddr).Port)
+	manager.GetServer(addr).SetCheckResult(true, time.Now())
+	pool := balancer.NewPool("default", balancer.NewRoundRobinAlgorithm(), func() []balancer.Backend {
+		var backends []balancer.Backend
+		for _, server := range manager.GetAllServers() {
+			st := server.Status()
+			backends = append(backends, balancer.Backend{Address: server.GetAddress(), Healthy: st.Healthy, Draining: st.Draining})
+		}
+		return backends
+	})
+	lb := balancer.NewLoadBalancer("", nil, balancer.NewRoundRobinAlgorithm())
+	lb.SetDefaultPool(pool)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	server := NewServer()
+	server.AddPool(&Pool{Name: "default", Manager: manager, Balancer: pool})
+	h := server.Handler()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testutil.WaitFor(t, "the connection to be proxied", func() bool { return pool.ActiveConnections(addr) == 1 })
+
+	rec := do(t, h, "POST", "/pools/default/backends/"+addr+"/drain", `{"timeout": "50ms", "force": true}`)
+	var result DrainResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if rec.Code != http.StatusOK || !result.Drained || result.Remaining != 0 || result.Closed != 1 {
+		t.Errorf("Unexpected drain result: %d %+v", rec.Code, result)
+	}
+}
+
+func TestServer_Affinity(t *testing.T) {
+	pool, addr := newTestPool(t)
+	server := NewServer()
+	server.AddPool(pool)
+	h := server.Handler()
+
+	if rec := do(t, h, "GET", "/pools/default/affinity", ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected conflict without an affinity table, got %d", rec.Code)
+	}
+
+	table := balancer.NewAffinityTable(time.Minute, 10)
+	table.Store(netip.MustParseAddr("192.0.2.1"), addr, time.Now())
+	table.Store(netip.MustParseAddr("192.0.2.2"), addr, time.Now())
+	pool.Balancer.SetAffinity(table)
+
+	rec := do(t, h, "GET", "/pools/default/affinity", "")
+	var list struct {
+		Entries []balancer.AffinityEntry `json:"entries"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if rec.Code != http.StatusOK || len(list.Entries) != 2 || list.Entries[0].Client != "192.0.2.1" || list.Entries[0].Backend != addr {
+		t.Fatalf("Unexpected affinity listing: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, h, "DELETE", "/pools/default/affinity/192.0.2.1", ""); rec.Code != http.StatusNoContent || table.Len() != 1 {
+		t.Errorf("Delete failed: %d, %d entries left", rec.Code, table.Len())
+	}
+	if rec := do(t, h, "DELETE", "/pools/default/affinity/nope", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid IP, got %d", rec.Code)
+	}
+	if rec := do(t, h, "DELETE", "/pools/default/affinity", ""); rec.Code != http.StatusNoContent || table.Len() != 0 {
+		t.Errorf("Clear failed: %d, %d entries left", rec.Code, table.Len())
+	}
+}
+
+func TestServer_Splits(t *testing.T) {
+	split, err := balancer.NewSplit("canary", "default", []balancer.SplitVariant{
+		{Pool: "default", Weight: 95},
+		{Pool: "canary", Weight: 5},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer()
+	server.AddSplit(split)
+	h := server.Handler()
+
+	rec := do(t, h, "GET", "/splits", "")
+	var list struct {
+		Splits []balancer.SplitStats `json:"splits"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if rec.Code != http.StatusOK || len(list.Splits) != 1 || list.Splits[0].Variants[1].Weight != 5 {
+		t.Fatalf("Unexpected split listing: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(t, h, "PUT", "/splits/canary/weights", `{"weights": {"default": 50, "canary": 50}}`)
+	if rec.Code != http.StatusOK || split.Variants()[1].Weight != 50 {
+		t.Errorf("Set weights failed: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "PUT", "/splits/canary/weights", `{"weights": {"default": 100}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for missing variant, got %d", rec.Code)
+	}
+	if rec := do(t, h, "GET", "/splits/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown split, got %d", rec.Code)
+	}
+}
+
+func TestServer_CircuitBreaker(t *testing.T) {
+	pool, addr := newTestPool(t)
+	server := NewServer()
+	server.AddPool(pool)
+	h := server.Handler()
+
+	if rec := do(t, h, "DELETE", "/pools/default/backends/"+addr+"/circuit_breaker", ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected conflict without circuit breakers, got %d", rec.Code)
+	}
+
+	pool.Balancer.SetCircuitBreaker(balancer.BreakerPolicy{})
+	rec := do(t, h, "DELETE", "/pools/default/backends/"+addr+"/circuit_breaker", "")
+	var status BackendStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != http.StatusOK || status.CircuitBreaker != string(balancer.BreakerClosed) {
+		t.Errorf("Reset failed: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServer_Connections(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	addr := backendLn.Addr().String()
+	lb := balancer.NewLoadBalancer("", []balancer.Backend{{Address: addr, Healthy: true}}, balancer.NewRoundRobinAlgorithm())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	server := NewServer()
+	server.AddPool(&Pool{Name: "default", Manager: backend.NewManager(), Balancer: lb.DefaultPool()})
+	h := server.Handler()
+
+	var clients []net.Conn
+	for range 2 {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+	}
+
+	var list struct {
+		Connections []balancer.ConnectionInfo `json:"connections"`
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(list.Connections) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 connections, got %d", len(list.Connections))
+		}
+		time.Sleep(10 * time.Millisecond)
+		rec := do(t, h, "GET", "/connections?backend="+addr+"&client=127.0.0.0/8", "")
+		json.Unmarshal(rec.Body.Bytes(), &list)
+	}
+	if c := list.Connections[0]; c.Pool != "default" || c.Backend != addr || c.Client != clients[0].LocalAddr().String() {
+		t.Errorf("Unexpected connection %+v", c)
+	}
+
+	rec := do(t, h, "GET", "/connections?client=192.0.2.0/24", "")
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if rec.Code != http.StatusOK || len(list.Connections) != 0 {
+		t.Errorf("Expected no connections from 192.0.2.0/24: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/connections?client=nope", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid client, got %d", rec.Code)
+	}
+	if rec := do(t, h, "GET", "/connections?pool=missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown pool, got %d", rec.Code)
+	}
+
+	rec = do(t, h, "GET", "/connections?pool=default", "")
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Connections) != 2 {
+		t.Fatalf("Expected 2 connections in pool default: %d %s", rec.Code, rec.Body)
+	}
+	id := list.Connections[0].ID
+	if rec := do(t, h, "DELETE", fmt.Sprintf("/connections/%d", id), ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Close failed: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "DELETE", "/connections/nope", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid ID, got %d", rec.Code)
+	}
+	if rec := do(t, h, "DELETE", "/connections", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request without a filter, got %d", rec.Code)
+	}
+
+	for len(lb.DefaultPool().Connections(balancer.ConnectionFilter{})) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Closed connection was never untracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = do(t, h, "DELETE", "/connections?client=127.0.0.1", "")
+	var result struct {
+		Closed int `json:"closed"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if rec.Code != http.StatusOK || result.Closed != 1 {
+		t.Errorf("Close by client failed: %d %s", rec.Code, rec.Body)
+	}
+}
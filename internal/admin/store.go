@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/config"
+)
+
+// store keeps the configuration file in sync with runtime changes
+type store struct {
+	path   string
+	cfg    *config.Config
+	always bool
+
+	// backends remembers each backend's configuration, such as TLS
+	// settings, by pool name and host:port
+	backends map[string]map[string]config.BackendConfig
+}
+
+func newStore(path string, cfg *config.Config, always bool) *store {
+	st := &store{
+		path:     path,
+		cfg:      cfg,
+		always:   always,
+		backends: make(map[string]map[string]config.BackendConfig),
+	}
+	for _, bc := range cfg.Backends {
+		st.add(balancer.DefaultPoolName, bc)
+	}
+	for _, pc := range cfg.Pools {
+		for _, bc := range pc.Backends {
+			st.add(pc.Name, bc)
+		}
+	}
+	return st
+}
+
+func (st *store) add(pool string, bc config.BackendConfig) {
+	if st.backends[pool] == nil {
+		st.backends[pool] = make(map[string]config.BackendConfig)
+	}
+	st.backends[pool][backendKey(bc)] = bc
+}
+
+func (st *store) remove(pool, address string) {
+	delete(st.backends[pool], address)
+}
+
+// save rebuilds the backend lists from the pools' current servers and
+// writes the configuration file
+func (st *store) save(pools map[string]*Pool) error {
+	for name, pool := range pools {
+		servers := pool.Manager.GetAllServers()
+		backends := make([]config.BackendConfig, 0, len(servers))
+		for _, server := range servers {
+			bc, ok := st.backends[name][server.GetAddress()]
+			if !ok {
+				bc = config.BackendConfig{Address: server.Address, Port: server.Port}
+			}
+			bc.Weight = server.GetWeight()
+			if bc.Weight == 1 {
+				bc.Weight = 0
+			}
+			backends = append(backends, bc)
+		}
+
+		if name == balancer.DefaultPoolName {
+			st.cfg.Backends = backends
+			continue
+		}
+		for i := range st.cfg.Pools {
+			if st.cfg.Pools[i].Name == name {
+				st.cfg.Pools[i].Backends = backends
+			}
+		}
+	}
+	return config.SaveConfig(st.path, st.cfg)
+}
+
+// backendKey returns the address the backend manager knows the backend by
+func backendKey(bc config.BackendConfig) string {
+	return backend.JoinAddress(bc.Address, bc.Port)
+}
@@ -1,22 +1,117 @@
 package backend
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"l4-load-balancer/pkg/dialer"
+)
+
+// ForcedState overrides the health check result of a server
+type ForcedState string
+
+// Forced states
+const (
+	ForceNone ForcedState = ""
+	ForceUp   ForcedState = "up"
+	ForceDown ForcedState = "down"
 )
 
-// Server represents a backend server
+// Server represents a backend server. Address is a host, or a full address
+// with a scheme such as unix:///run/app.sock.
 type Server struct {
 	Address     string
 	Port        int
 	Healthy     bool
 	LastChecked time.Time
+
+	// TLSConfig, when set, makes connections and health probes to the
+	// server use TLS. It is fixed when the server is added.
+	TLSConfig *tls.Config
+
+	// Dialer opens connections and health probes to the server; nil uses
+	// dialer.Default. It is fixed when the server is added.
+	Dialer dialer.Dialer
+
+	// Weight is the server's relative share of traffic; values below 1
+	// count as 1
+	Weight int
+
+	// LastCheckPassed is the result of the most recent health check,
+	// regardless of any forced state
+	LastCheckPassed bool
+
+	// Forced overrides the health check result when set
+	Forced ForcedState
+
+	// Priority is the server's priority group; lower values are preferred
+	Priority int
+
+	// Zone is the availability zone the server runs in
+	Zone string
+
+	// HealthySince is when the server last became healthy
+	HealthySince time.Time
+
+	// Draining servers keep their existing connections but receive no new
+	// ones
+	Draining bool
+
+	// manager is notified of health changes while the server belongs to it
+	manager *Manager
+
+	mu sync.RWMutex
+}
+
+// Status is a point-in-time copy of a server's state
+type Status struct {
+	Address         string
+	Port            int
+	Healthy         bool
+	Weight          int
+	Priority        int
+	Zone            string
+	Forced          ForcedState
+	Draining        bool
+	HealthySince    time.Time
+	LastChecked     time.Time
+	LastCheckPassed bool
+}
+
+// ServerOptions configures a server as it is added, before observers,
+// health checks or connections can see it
+type ServerOptions struct {
+	// Weight below 1 counts as 1
+	Weight   int
+	Priority int
+	Zone     string
+
+	TLSConfig *tls.Config
+
+	// Dialer overrides the manager's dialer for this server
+	Dialer dialer.Dialer
+}
+
+// Observer is notified of changes to a manager's servers. Methods are
+// called without the manager's or server's locks held.
+type Observer interface {
+	ServerAdded(server *Server)
+	ServerRemoved(server *Server)
+	HealthChanged(server *Server, healthy bool)
 }
 
 // Manager manages backend servers
 type Manager struct {
-	servers []*Server
+	mu        sync.RWMutex
+	servers   []*Server
+	observers []Observer
+	dialer    dialer.Dialer
 }
 
 // NewManager creates a new backend manager
@@ -26,21 +121,112 @@ func NewManager() *Manager {
 	}
 }
 
-// AddServer adds a backend server
-func (m *Manager) AddServer(address string, port int) {
+// AddServer adds a backend server with default options
+func (m *Manager) AddServer(address string, port int) *Server {
+	return m.AddServerWithOptions(address, port, ServerOptions{})
+}
+
+// AddServerWithOptions adds a backend server configured with opts. The
+// server is complete before it is published to observers and other readers.
+func (m *Manager) AddServerWithOptions(address string, port int, opts ServerOptions) *Server {
 	server := &Server{
-		Address: address,
-		Port:    port,
-		Healthy: false,
+		Address:   address,
+		Port:      port,
+		Healthy:   false,
+		Weight:    max(opts.Weight, 1),
+		Priority:  opts.Priority,
+		Zone:      opts.Zone,
+		TLSConfig: opts.TLSConfig,
+		Dialer:    opts.Dialer,
+		manager:   m,
+	}
+
+	m.mu.Lock()
+	if server.Dialer == nil {
+		server.Dialer = m.dialer
 	}
 	m.servers = append(m.servers, server)
+	observers := m.observers
+	m.mu.Unlock()
+
+	for _, o := range observers {
+		o.ServerAdded(server)
+	}
+	return server
+}
+
+// RemoveServer removes the server with the given host:port address and
+// returns it, or nil if there is no such server
+func (m *Manager) RemoveServer(address string) *Server {
+	m.mu.Lock()
+	var removed *Server
+	for i, server := range m.servers {
+		if server.GetAddress() == address {
+			m.servers = append(m.servers[:i:i], m.servers[i+1:]...)
+			removed = server
+			break
+		}
+	}
+	observers := m.observers
+	m.mu.Unlock()
+
+	if removed == nil {
+		return nil
+	}
+	removed.mu.Lock()
+	removed.manager = nil
+	removed.mu.Unlock()
+	for _, o := range observers {
+		o.ServerRemoved(removed)
+	}
+	return removed
+}
+
+// SetDialer sets the dialer given to servers added from now on
+func (m *Manager) SetDialer(d dialer.Dialer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dialer = d
+}
+
+// AddObserver registers o to be notified of changes to the servers
+func (m *Manager) AddObserver(o Observer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, o)
+}
+
+// healthChanged notifies the observers that a server's health changed
+func (m *Manager) healthChanged(server *Server, healthy bool) {
+	m.mu.RLock()
+	observers := m.observers
+	m.mu.RUnlock()
+	for _, o := range observers {
+		o.HealthChanged(server, healthy)
+	}
+}
+
+// GetServer returns the server with the given host:port address, or nil
+func (m *Manager) GetServer(address string) *Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, server := range m.servers {
+		if server.GetAddress() == address {
+			return server
+		}
+	}
+	return nil
 }
 
 // GetHealthyServers returns all healthy servers
 func (m *Manager) GetHealthyServers() []*Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	healthy := make([]*Server, 0)
 	for _, server := range m.servers {
-		if server.Healthy {
+		if server.IsHealthy() {
 			healthy = append(healthy, server)
 		}
 	}
@@ -49,17 +235,216 @@ func (m *Manager) GetHealthyServers() []*Server {
 
 // GetAllServers returns all servers
 func (m *Manager) GetAllServers() []*Server {
-	return m.servers
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	servers := make([]*Server, len(m.servers))
+	copy(servers, m.servers)
+	return servers
 }
 
 // GetAddress returns the full address of the server
 func (s *Server) GetAddress() string {
-	return fmt.Sprintf("%s:%d", s.Address, s.Port)
+	return JoinAddress(s.Address, s.Port)
 }
 
-// IsReachable checks if the server is reachable
+// JoinAddress returns the full address of a server configured with address
+// and port: host:port for TCP servers, or the address itself for other
+// schemes such as unix://
+func JoinAddress(address string, port int) string {
+	if strings.Contains(address, "://") && !strings.HasPrefix(address, dialer.SchemeTCP) {
+		return address
+	}
+	host := strings.TrimPrefix(address, dialer.SchemeTCP)
+	if port == 0 {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return host
+		}
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// CheckAddress reports whether a full server address can be dialed: a
+// supported scheme and, for TCP, a valid port
+func CheckAddress(address string) error {
+	network, addr, err := dialer.Split(address)
+	if err != nil {
+		return err
+	}
+	if network != "tcp" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("address %q: %w", address, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("address %q has no valid port", address)
+	}
+	return nil
+}
+
+// IsHealthy reports whether the server may receive traffic
+func (s *Server) IsHealthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Healthy
+}
+
+// GetWeight returns the server's weight, at least 1
+func (s *Server) GetWeight() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.Weight < 1 {
+		return 1
+	}
+	return s.Weight
+}
+
+// SetWeight changes the server's share of traffic
+func (s *Server) SetWeight(weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Weight = weight
+}
+
+// GetPriority returns the server's priority group
+func (s *Server) GetPriority() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Priority
+}
+
+// SetPriority moves the server to another priority group
+func (s *Server) SetPriority(priority int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Priority = priority
+}
+
+// SetZone records the availability zone the server runs in
+func (s *Server) SetZone(zone string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Zone = zone
+}
+
+// IsDraining reports whether the server is being drained
+func (s *Server) IsDraining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Draining
+}
+
+// SetDraining starts or stops draining the server and reports whether the
+// state changed
+func (s *Server) SetDraining(draining bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.Draining != draining
+	s.Draining = draining
+	return changed
+}
+
+// SetCheckResult records a health check result and reports whether the
+// server's effective health changed
+func (s *Server) SetCheckResult(passed bool, at time.Time) bool {
+	s.mu.Lock()
+	s.LastChecked = at
+	s.LastCheckPassed = passed
+	changed := s.updateHealth(at)
+	s.mu.Unlock()
+
+	if changed {
+		s.notifyHealth()
+	}
+	return changed
+}
+
+// SetForcedState overrides the health check result, or clears the override
+// with ForceNone, and reports whether the effective health changed
+func (s *Server) SetForcedState(state ForcedState) bool {
+	s.mu.Lock()
+	s.Forced = state
+	changed := s.updateHealth(time.Now())
+	s.mu.Unlock()
+
+	if changed {
+		s.notifyHealth()
+	}
+	return changed
+}
+
+// notifyHealth tells the server's manager about a change in its health
+func (s *Server) notifyHealth() {
+	s.mu.RLock()
+	manager, healthy := s.manager, s.Healthy
+	s.mu.RUnlock()
+	if manager != nil {
+		manager.healthChanged(s, healthy)
+	}
+}
+
+// updateHealth recomputes Healthy from the check result and forced state
+func (s *Server) updateHealth(now time.Time) bool {
+	healthy := s.LastCheckPassed
+	switch s.Forced {
+	case ForceUp:
+		healthy = true
+	case ForceDown:
+		healthy = false
+	}
+	changed := healthy != s.Healthy
+	if changed && healthy {
+		s.HealthySince = now
+	}
+	s.Healthy = healthy
+	return changed
+}
+
+// Status returns a snapshot of the server's state
+func (s *Server) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Status{
+		Address:         s.Address,
+		Port:            s.Port,
+		Healthy:         s.Healthy,
+		Weight:          s.Weight,
+		Priority:        s.Priority,
+		Zone:            s.Zone,
+		Forced:          s.Forced,
+		Draining:        s.Draining,
+		HealthySince:    s.HealthySince,
+		LastChecked:     s.LastChecked,
+		LastCheckPassed: s.LastCheckPassed,
+	}
+}
+
+// DefaultProbeTimeout bounds reachability checks that don't specify a timeout
+const DefaultProbeTimeout = 5 * time.Second
+
+// IsReachable checks if the server is reachable. For TLS servers the
+// handshake must also succeed.
 func (s *Server) IsReachable() bool {
-	conn, err := net.DialTimeout("tcp", s.GetAddress(), 5*time.Second)
+	return s.IsReachableWithin(DefaultProbeTimeout)
+}
+
+// IsReachableWithin checks if the server is reachable within timeout
+func (s *Server) IsReachableWithin(timeout time.Duration) bool {
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if s.TLSConfig != nil {
+		conn, err = dialer.DialTLS(ctx, s.Dialer, s.GetAddress(), s.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(ctx, s.Dialer, s.GetAddress())
+	}
 	if err != nil {
 		return false
 	}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJoinAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		port    int
+		want    string
+	}{
+		{"10.0.0.1", 8080, "10.0.0.1:8080"},
+		{"tcp://10.0.0.1", 8080, "10.0.0.1:8080"},
+		{"tcp://10.0.0.1:8080", 0, "10.0.0.1:8080"},
+		{"unix:///run/app.sock", 0, "unix:///run/app.sock"},
+	}
+	for _, tt := range tests {
+		if got := JoinAddress(tt.address, tt.port); got != tt.want {
+			t.Errorf("JoinAddress(%q, %d) = %q, want %q", tt.address, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	for _, address := range []string{"10.0.0.1:8080", "unix:///run/app.sock"} {
+		if err := CheckAddress(address); err != nil {
+			t.Errorf("CheckAddress(%q) = %v", address, err)
+		}
+	}
+	for _, address := range []string{"10.0.0.1:0", "10.0.0.1:70000", "10.0.0.1", "udp://10.0.0.1:53", "unix://"} {
+		if err := CheckAddress(address); err == nil {
+			t.Errorf("CheckAddress(%q) succeeded, want error", address)
+		}
+	}
+}
+
+func TestServer_IsReachableUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backend.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	manager := NewManager()
+	server := manager.AddServer("unix://"+path, 0)
+	if !server.IsReachableWithin(time.Second) {
+		t.Error("unix socket backend not reachable")
+	}
+	ln.Close()
+	if server.IsReachableWithin(time.Second) {
+		t.Error("closed unix socket backend reachable")
+	}
+}
+
+// addedObserver records what a server looks like when it is published
+type addedObserver struct {
+	added []ServerOptions
+}
+
+func (o *addedObserver) ServerAdded(server *Server) {
+	o.added = append(o.added, ServerOptions{
+		TLSConfig: server.TLSConfig,
+		Dialer:    server.Dialer,
+		Weight:    server.GetWeight(),
+		Priority:  server.GetPriority(),
+		Zone:      server.Status().Zone,
+	})
+}
+func (o *addedObserver) ServerRemoved(*Server)       {}
+func (o *addedObserver) HealthChanged(*Server, bool) {}
+
+func TestManager_AddServerPublishesCompleteServer(t *testing.T) {
+	manager := NewManager()
+	managerDialer := &net.Dialer{}
+	manager.SetDialer(managerDialer)
+	observer := &addedObserver{}
+	manager.AddObserver(observer)
+
+	tlsConfig := &tls.Config{ServerName: "backend"}
+	manager.AddServerWithOptions("10.0.0.1", 8080, ServerOptions{Weight: 3, Priority: 1, Zone: "zone-a", TLSConfig: tlsConfig})
+	manager.AddServer("10.0.0.2", 8080)
+
+	if len(observer.added) != 2 {
+		t.Fatalf("Expected 2 servers added, got %d", len(observer.added))
+	}
+	got := observer.added[0]
+	if got.TLSConfig != tlsConfig || got.Weight != 3 || got.Priority != 1 || got.Zone != "zone-a" || got.Dialer != managerDialer {
+		t.Errorf("Observer saw an incomplete server: %+v", got)
+	}
+	if got := observer.added[1]; got.Weight != 1 || got.TLSConfig != nil {
+		t.Errorf("Expected defaults for a server without options, got %+v", got)
+	}
+}
@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"l4-load-balancer/internal/metrics"
+	"l4-load-balancer/pkg/dialer"
+	"l4-load-balancer/pkg/pool"
+)
+
+// DefaultPoolMaxSize bounds the connections pooled per server when no size
+// is configured
+const DefaultPoolMaxSize = 100
+
+// PoolOptions sizes and tunes the connection pools of a PoolRegistry
+type PoolOptions struct {
+	// Name is the load balancer pool the connection pools serve, used to
+	// label their metrics
+	Name string
+
+	MaxSize     int
+	MinIdle     int
+	MaxIdleTime time.Duration
+	MaxLifetime time.Duration
+	DialTimeout time.Duration
+	Dialer      dialer.Dialer
+}
+
+// PoolRegistry keeps a connection pool for each of a manager's servers.
+// Pools are created as servers are added, warmed when a server becomes
+// healthy, flushed when it becomes unhealthy and closed when it is removed.
+type PoolRegistry struct {
+	options PoolOptions
+
+	mu     sync.RWMutex
+	pools  map[string]*pool.ConnectionPool
+	closed bool
+}
+
+// NewPoolRegistry creates pools for the manager's servers and keeps them in
+// step with the manager from then on
+func NewPoolRegistry(manager *Manager, options PoolOptions) *PoolRegistry {
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultPoolMaxSize
+	}
+	r := &PoolRegistry{
+		options: options,
+		pools:   make(map[string]*pool.ConnectionPool),
+	}
+	manager.AddObserver(r)
+	for _, server := range manager.GetAllServers() {
+		r.ServerAdded(server)
+		if server.IsHealthy() {
+			r.HealthChanged(server, true)
+		}
+	}
+	return r
+}
+
+// Pool returns the connection pool for the server at address, or nil
+func (r *PoolRegistry) Pool(address string) *pool.ConnectionPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pools[address]
+}
+
+// Stats returns the statistics of every pool by server address
+func (r *PoolRegistry) Stats() map[string]pool.Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make(map[string]pool.Stats, len(r.pools))
+	for address, p := range r.pools {
+		stats[address] = p.Stats()
+	}
+	return stats
+}
+
+// ServerAdded creates a pool for the server
+func (r *PoolRegistry) ServerAdded(server *Server) {
+	address := server.GetAddress()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.pools[address] != nil {
+		return
+	}
+
+	p := pool.NewConnectionPool(address, r.options.MaxSize)
+	if r.options.Dialer != nil {
+		p.SetDialer(r.options.Dialer)
+	}
+	if r.options.DialTimeout > 0 {
+		p.SetDialTimeout(r.options.DialTimeout)
+	}
+	p.SetMinIdle(r.options.MinIdle)
+	p.SetMaxIdleTime(r.options.MaxIdleTime)
+	p.SetMaxLifetime(r.options.MaxLifetime)
+	r.pools[address] = p
+	metrics.ObserveConnectionPool(r.options.Name, address, p)
+}
+
+// ServerRemoved closes the server's pool
+func (r *PoolRegistry) ServerRemoved(server *Server) {
+	address := server.GetAddress()
+	r.mu.Lock()
+	p := r.pools[address]
+	delete(r.pools, address)
+	r.mu.Unlock()
+
+	if p != nil {
+		p.Close()
+		metrics.ForgetConnectionPool(r.options.Name, address)
+	}
+}
+
+// HealthChanged warms the server's pool when it becomes healthy and closes
+// its idle connections when it becomes unhealthy. Servers reached over TLS
+// are not warmed, as pooled connections are plain TCP.
+func (r *PoolRegistry) HealthChanged(server *Server, healthy bool) {
+	p := r.Pool(server.GetAddress())
+	if p == nil {
+		return
+	}
+	if !healthy {
+		p.Flush()
+		return
+	}
+	if server.TLSConfig != nil || r.options.MinIdle <= 0 {
+		return
+	}
+	go func() {
+		if err := p.Warm(); err != nil {
+			slog.Warn("Warming connection pool failed", "backend", server.GetAddress(), "error", err)
+		}
+	}()
+}
+
+// Close closes every pool; pools are no longer created afterwards
+func (r *PoolRegistry) Close() {
+	r.mu.Lock()
+	pools := r.pools
+	r.pools = make(map[string]*pool.ConnectionPool)
+	r.closed = true
+	r.mu.Unlock()
+
+	for address, p := range pools {
+		p.Close()
+		metrics.ForgetConnectionPool(r.options.Name, address)
+	}
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/metrics"
+	"l4-load-balancer/internal/testutil"
+)
+
+// listen starts a backend that reads and discards whatever it is sent
+func listen(t *testing.T) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func TestPoolRegistry_FollowsServers(t *testing.T) {
+	manager := NewManager()
+	host, port := listen(t)
+	existing := manager.AddServer(host, port)
+	existing.SetCheckResult(true, time.Now())
+
+	r := NewPoolRegistry(manager, PoolOptions{MaxSize: 4, MinIdle: 2})
+	defer r.Close()
+
+	// Pools of servers that were already healthy are warmed
+	pool := r.Pool(existing.GetAddress())
+	if pool == nil {
+		t.Fatal("no pool for an existing server")
+	}
+	testutil.WaitFor(t, "pool to be warmed", func() bool { return pool.PoolSize() == 2 })
+
+	host, port = listen(t)
+	added := manager.AddServer(host, port)
+	addedPool := r.Pool(added.GetAddress())
+	if addedPool == nil {
+		t.Fatal("no pool for an added server")
+	}
+	if got := addedPool.PoolSize(); got != 0 {
+		t.Errorf("pool of an unchecked server has %d idle connections", got)
+	}
+	added.SetCheckResult(true, time.Now())
+	testutil.WaitFor(t, "pool to be warmed", func() bool { return addedPool.PoolSize() == 2 })
+
+	// Turning unhealthy flushes the pool
+	added.SetForcedState(ForceDown)
+	if got := addedPool.PoolSize(); got != 0 {
+		t.Errorf("pool of an unhealthy server has %d idle connections", got)
+	}
+
+	manager.RemoveServer(added.GetAddress())
+	if r.Pool(added.GetAddress()) != nil {
+		t.Error("pool of a removed server still registered")
+	}
+	if _, err := addedPool.Get(); err == nil {
+		t.Error("pool of a removed server was not closed")
+	}
+
+	// Health changes of a removed server are ignored
+	added.SetForcedState(ForceUp)
+	if r.Pool(added.GetAddress()) != nil {
+		t.Error("removed server's pool recreated")
+	}
+
+	r.Close()
+	if got := pool.ActiveConnections(); got != 0 {
+		t.Errorf("pool has %d connections after Close", got)
+	}
+	manager.AddServer(host, port+1)
+	if r.Pool(net.JoinHostPort(host, strconv.Itoa(port+1))) != nil {
+		t.Error("pool created after Close")
+	}
+}
+
+func TestPoolRegistry_MetricsPerPool(t *testing.T) {
+	host, port := listen(t)
+	first, second := NewManager(), NewManager()
+	server := first.AddServer(host, port)
+	second.AddServer(host, port)
+
+	r1 := NewPoolRegistry(first, PoolOptions{Name: "first"})
+	defer r1.Close()
+	r2 := NewPoolRegistry(second, PoolOptions{Name: "second"})
+	defer r2.Close()
+	series := func(pool string) bool {
+		var out bytes.Buffer
+		metrics.DefaultRegistry.WriteText(&out)
+		return strings.Contains(out.String(), `lb_connection_pool_idle_connections{pool="`+pool+`",address="`+server.GetAddress()+`"}`)
+	}
+	if !series("first") || !series("second") {
+		t.Fatal("Expected a series for each pool's connection pool")
+	}
+
+	// Removing the server from one pool leaves the other's series
+	first.RemoveServer(server.GetAddress())
+	if series("first") || !series("second") {
+		t.Error("Expected only the first pool's series to be deleted")
+	}
+}
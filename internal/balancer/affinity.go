@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"container/list"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Affinity table defaults
+const (
+	DefaultAffinityTTL  = 30 * time.Minute
+	DefaultAffinitySize = 65536
+)
+
+// AffinityEntry is a client IP remembered as belonging to a backend
+type AffinityEntry struct {
+	Client  string    `json:"client"`
+	Backend string    `json:"backend"`
+	Expires time.Time `json:"expires"`
+}
+
+// affinityEntry is an AffinityEntry in the table's LRU list
+type affinityEntry struct {
+	client  netip.Addr
+	backend string
+	expires time.Time
+}
+
+// AffinityTable remembers which backend each client IP was sent to so later
+// connections from the same client go to the same backend. Entries expire
+// after the TTL without use, and the least recently used entries are
+// evicted once the table is full.
+type AffinityTable struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	entries  map[netip.Addr]*list.Element
+	lru      *list.List
+}
+
+// NewAffinityTable creates an affinity table; non-positive values use the
+// defaults
+func NewAffinityTable(ttl time.Duration, capacity int) *AffinityTable {
+	t := &AffinityTable{
+		entries: make(map[netip.Addr]*list.Element),
+		lru:     list.New(),
+	}
+	t.Configure(ttl, capacity)
+	return t
+}
+
+// Configure changes the TTL and capacity, keeping existing entries that
+// still fit
+func (t *AffinityTable) Configure(ttl time.Duration, capacity int) {
+	if ttl <= 0 {
+		ttl = DefaultAffinityTTL
+	}
+	if capacity <= 0 {
+		capacity = DefaultAffinitySize
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl = ttl
+	t.capacity = capacity
+	for t.lru.Len() > t.capacity {
+		t.remove(t.lru.Back())
+	}
+}
+
+// Lookup returns the backend remembered for client, if any
+func (t *AffinityTable) Lookup(client netip.Addr, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[client]
+	if !ok {
+		return "", false
+	}
+	e := elem.Value.(*affinityEntry)
+	if now.After(e.expires) {
+		t.remove(elem)
+		return "", false
+	}
+	t.lru.MoveToFront(elem)
+	return e.backend, true
+}
+
+// Store remembers that client was sent to backend and restarts its TTL
+func (t *AffinityTable) Store(client netip.Addr, backend string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[client]; ok {
+		e := elem.Value.(*affinityEntry)
+		e.backend = backend
+		e.expires = now.Add(t.ttl)
+		t.lru.MoveToFront(elem)
+		return
+	}
+
+	for t.lru.Len() >= t.capacity {
+		t.remove(t.lru.Back())
+	}
+	t.entries[client] = t.lru.PushFront(&affinityEntry{
+		client:  client,
+		backend: backend,
+		expires: now.Add(t.ttl),
+	})
+}
+
+// Delete forgets client
+func (t *AffinityTable) Delete(client netip.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if elem, ok := t.entries[client]; ok {
+		t.remove(elem)
+	}
+}
+
+// Clear forgets every client
+func (t *AffinityTable) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = make(map[netip.Addr]*list.Element)
+	t.lru.Init()
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (t *AffinityTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+// Entries returns the unexpired entries sorted by client
+func (t *AffinityTable) Entries(now time.Time) []AffinityEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]AffinityEntry, 0, t.lru.Len())
+	for elem := t.lru.Front(); elem != nil; {
+		next := elem.Next()
+		e := elem.Value.(*affinityEntry)
+		if now.After(e.expires) {
+			t.remove(elem)
+		} else {
+			entries = append(entries, AffinityEntry{
+				Client:  e.client.String(),
+				Backend: e.backend,
+				Expires: e.expires,
+			})
+		}
+		elem = next
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Client < entries[j].Client })
+	return entries
+}
+
+// remove deletes elem from the table. Callers hold t.mu.
+func (t *AffinityTable) remove(elem *list.Element) {
+	t.lru.Remove(elem)
+	delete(t.entries, elem.Value.(*affinityEntry).client)
+}
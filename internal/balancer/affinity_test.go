@@ -0,0 +1,110 @@
+package balancer
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// liveAddress returns the address of a listener that accepts and closes
+// connections until the test ends
+func liveAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestAffinityTable_TTLAndEviction(t *testing.T) {
+	now := time.Now()
+	table := NewAffinityTable(time.Minute, 2)
+	a := netip.MustParseAddr("10.0.0.1")
+	b := netip.MustParseAddr("10.0.0.2")
+	c := netip.MustParseAddr("10.0.0.3")
+
+	table.Store(a, "server1:8081", now)
+	table.Store(b, "server2:8082", now)
+	if backend, ok := table.Lookup(a, now.Add(30*time.Second)); !ok || backend != "server1:8081" {
+		t.Errorf("Expected a to stick to server1:8081, got %q %v", backend, ok)
+	}
+
+	// a was used more recently, so b is evicted
+	table.Store(c, "server1:8081", now)
+	if _, ok := table.Lookup(b, now); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if table.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", table.Len())
+	}
+
+	if _, ok := table.Lookup(c, now.Add(2*time.Minute)); ok {
+		t.Error("Expected entry to expire after the TTL")
+	}
+	if entries := table.Entries(now); len(entries) != 1 || entries[0].Client != "10.0.0.1" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+
+	// Shrinking keeps the most recently used entries
+	table.Store(b, "server2:8082", now)
+	table.Configure(time.Minute, 1)
+	if _, ok := table.Lookup(b, now); !ok || table.Len() != 1 {
+		t.Errorf("Expected only the newest entry to remain, have %d", table.Len())
+	}
+}
+
+func TestPool_AffinityKeepsClientOnBackend(t *testing.T) {
+	backends := []Backend{
+		{Address: liveAddress(t), Healthy: true},
+		{Address: liveAddress(t), Healthy: true},
+	}
+	pool := NewPool("test", NewRoundRobinAlgorithm(), func() []Backend { return backends })
+	pool.SetAffinity(NewAffinityTable(time.Minute, 10))
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+
+	first, conn, _, err := pool.connect(client, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	for i := 0; i < 3; i++ {
+		backend, conn, _, err := pool.connect(&net.TCPAddr{IP: client.IP, Port: 40001 + i}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if backend.Address != first.Address {
+			t.Fatalf("Expected client to stick to %s, got %s", first.Address, backend.Address)
+		}
+	}
+
+	// Once the backend is unavailable the client moves and sticks to the other
+	for i := range backends {
+		if backends[i].Address == first.Address {
+			backends[i].Draining = true
+		}
+	}
+	moved, conn, _, err := pool.connect(client, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if moved.Address == first.Address {
+		t.Fatal("Expected client to move off the draining backend")
+	}
+	if backend, _ := pool.Affinity().Lookup(netip.MustParseAddr("192.0.2.1"), time.Now()); backend != moved.Address {
+		t.Errorf("Expected affinity to follow the new backend, got %s", backend)
+	}
+}
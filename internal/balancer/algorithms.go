@@ -1,6 +1,9 @@
 package balancer
 
 import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
 	"sync/atomic"
 )
 
@@ -23,7 +26,7 @@ func (rr *RoundRobinAlgorithm) SelectBackend(backends []Backend) *Backend {
 	// Filter healthy backends
 	healthy := make([]Backend, 0, len(backends))
 	for _, backend := range backends {
-		if backend.Healthy {
+		if backend.available() {
 			healthy = append(healthy, backend)
 		}
 	}
@@ -36,9 +39,61 @@ func (rr *RoundRobinAlgorithm) SelectBackend(backends []Backend) *Backend {
 	return &healthy[index]
 }
 
-// LeastConnectionsAlgorithm implements least connections load balancing
+// WeightedRoundRobinAlgorithm implements smooth weighted round-robin: each
+// backend receives traffic in proportion to its weight, interleaved rather
+// than in bursts
+type WeightedRoundRobinAlgorithm struct {
+	mu      sync.Mutex
+	current map[string]float64
+}
+
+// NewWeightedRoundRobinAlgorithm creates a new weighted round-robin algorithm
+func NewWeightedRoundRobinAlgorithm() *WeightedRoundRobinAlgorithm {
+	return &WeightedRoundRobinAlgorithm{
+		current: make(map[string]float64),
+	}
+}
+
+// SelectBackend selects the next backend by weight
+func (wrr *WeightedRoundRobinAlgorithm) SelectBackend(backends []Backend) *Backend {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	var best *Backend
+	total := 0.0
+	seen := make(map[string]bool, len(backends))
+	for i := range backends {
+		backend := &backends[i]
+		if !backend.available() {
+			continue
+		}
+		weight := backend.EffectiveWeight()
+		seen[backend.Address] = true
+		total += weight
+		wrr.current[backend.Address] += weight
+		if best == nil || wrr.current[backend.Address] > wrr.current[best.Address] {
+			best = backend
+		}
+	}
+
+	// Forget backends that are gone or unhealthy so they restart fairly
+	for address := range wrr.current {
+		if !seen[address] {
+			delete(wrr.current, address)
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	wrr.current[best.Address] -= total
+	return best
+}
+
+// LeastConnectionsAlgorithm implements least connections load balancing.
+// Connections are compared relative to each backend's effective weight.
 type LeastConnectionsAlgorithm struct {
-	// TODO: Track connections per backend
+	counter uint64
 }
 
 // NewLeastConnectionsAlgorithm creates a new least connections algorithm
@@ -48,6 +103,104 @@ func NewLeastConnectionsAlgorithm() *LeastConnectionsAlgorithm {
 
 // SelectBackend selects the backend with least connections
 func (lc *LeastConnectionsAlgorithm) SelectBackend(backends []Backend) *Backend {
-	// TODO: Implement least connections logic
-	return nil
+	if len(backends) == 0 {
+		return nil
+	}
+
+	// Start at a rotating offset so ties are spread across backends
+	start := int(atomic.AddUint64(&lc.counter, 1) % uint64(len(backends)))
+
+	var best *Backend
+	var bestLoad float64
+	for i := range backends {
+		backend := &backends[(start+i)%len(backends)]
+		if !backend.available() {
+			continue
+		}
+		load := backend.load()
+		if best == nil || load < bestLoad {
+			best, bestLoad = backend, load
+		}
+	}
+	return best
+}
+
+// load returns the backend's connections relative to its effective weight,
+// counting the connection about to be placed so that weight still matters
+// for idle backends
+func (b *Backend) load() float64 {
+	return float64(b.ActiveConnections+1) / b.EffectiveWeight()
+}
+
+// PowerOfTwoChoicesAlgorithm picks two backends at random and sends the
+// connection to the less loaded one
+type PowerOfTwoChoicesAlgorithm struct{}
+
+// NewPowerOfTwoChoicesAlgorithm creates a new power-of-two-choices algorithm
+func NewPowerOfTwoChoicesAlgorithm() *PowerOfTwoChoicesAlgorithm {
+	return &PowerOfTwoChoicesAlgorithm{}
+}
+
+// SelectBackend selects the less loaded of two random available backends
+func (p2c *PowerOfTwoChoicesAlgorithm) SelectBackend(backends []Backend) *Backend {
+	available := make([]int, 0, len(backends))
+	for i := range backends {
+		if backends[i].available() {
+			available = append(available, i)
+		}
+	}
+
+	switch len(available) {
+	case 0:
+		return nil
+	case 1:
+		return &backends[available[0]]
+	}
+
+	i := rand.IntN(len(available))
+	j := rand.IntN(len(available) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := &backends[available[i]], &backends[available[j]]
+	if b.load() < a.load() {
+		return b
+	}
+	return a
+}
+
+// algorithmName returns the name a is registered under, looking through
+// the priority and zone wrappers
+func algorithmName(a Algorithm) string {
+	switch a := a.(type) {
+	case *RoundRobinAlgorithm:
+		return "round_robin"
+	case *WeightedRoundRobinAlgorithm:
+		return "weighted_round_robin"
+	case *LeastConnectionsAlgorithm:
+		return "least_connections"
+	case *PowerOfTwoChoicesAlgorithm:
+		return "p2c"
+	case *PriorityAlgorithm:
+		return algorithmName(a.next)
+	case *ZoneAwareAlgorithm:
+		return algorithmName(a.next)
+	}
+	return "custom"
+}
+
+// NewAlgorithm returns the algorithm registered under name
+func NewAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "", "round_robin":
+		return NewRoundRobinAlgorithm(), nil
+	case "weighted_round_robin":
+		return NewWeightedRoundRobinAlgorithm(), nil
+	case "least_connections":
+		return NewLeastConnectionsAlgorithm(), nil
+	case "p2c", "power_of_two_choices":
+		return NewPowerOfTwoChoicesAlgorithm(), nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing algorithm %q", name)
+	}
 }
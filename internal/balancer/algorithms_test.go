@@ -214,3 +214,164 @@ func BenchmarkRoundRobinAlgorithm_SelectBackend(b *testing.B) {
 		}
 	})
 }
+
+func TestWeightedRoundRobinAlgorithm_SelectBackend(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true, Weight: 5},
+		{Address: "server2:8082", Healthy: true, Weight: 1},
+		{Address: "server3:8083", Healthy: true, Weight: 1},
+		{Address: "server4:8084", Healthy: false, Weight: 10},
+	}
+
+	wrr := NewWeightedRoundRobinAlgorithm()
+	counts := make(map[string]int)
+	sequence := make([]string, 0, 7)
+	for i := 0; i < 70; i++ {
+		backend := wrr.SelectBackend(backends)
+		if backend == nil {
+			t.Fatalf("Request %d: expected a backend", i)
+		}
+		counts[backend.Address]++
+		if i < 7 {
+			sequence = append(sequence, backend.Address)
+		}
+	}
+
+	expected := map[string]int{"server1:8081": 50, "server2:8082": 10, "server3:8083": 10}
+	for address, count := range expected {
+		if counts[address] != count {
+			t.Errorf("Backend %s: expected %d selections, got %d", address, count, counts[address])
+		}
+	}
+	if counts["server4:8084"] != 0 {
+		t.Error("Unhealthy backend was selected")
+	}
+
+	// Smooth weighting interleaves the light backends with the heavy one
+	// instead of sending five connections in a row to server1
+	run := 0
+	for _, address := range sequence {
+		if address == "server1:8081" {
+			run++
+			if run > 3 {
+				t.Errorf("Heavy backend selected %d times in a row: %v", run, sequence)
+			}
+		} else {
+			run = 0
+		}
+	}
+}
+
+func TestWeightedRoundRobinAlgorithm_ZeroWeightCountsAsOne(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true},
+		{Address: "server2:8082", Healthy: true},
+	}
+
+	wrr := NewWeightedRoundRobinAlgorithm()
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[wrr.SelectBackend(backends).Address]++
+	}
+	if counts["server1:8081"] != 5 || counts["server2:8082"] != 5 {
+		t.Errorf("Expected an even split, got %v", counts)
+	}
+}
+
+func TestLeastConnectionsAlgorithm_SelectBackend(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true, ActiveConnections: 4},
+		{Address: "server2:8082", Healthy: true, ActiveConnections: 1},
+		{Address: "server3:8083", Healthy: false, ActiveConnections: 0},
+		{Address: "server4:8084", Healthy: true, Weight: 4, ActiveConnections: 8},
+	}
+
+	lc := NewLeastConnectionsAlgorithm()
+	for i := 0; i < 5; i++ {
+		backend := lc.SelectBackend(backends)
+		if backend == nil || backend.Address != "server2:8082" {
+			t.Fatalf("Expected server2:8082, got %v", backend)
+		}
+	}
+
+	// Relative to its weight, server4 is now the least loaded
+	backends[1].ActiveConnections = 2
+	if backend := lc.SelectBackend(backends); backend == nil || backend.Address != "server4:8084" {
+		t.Errorf("Expected server4:8084, got %v", backend)
+	}
+}
+
+func TestLeastConnectionsAlgorithm_SpreadsTies(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true},
+		{Address: "server2:8082", Healthy: true},
+		{Address: "server3:8083", Healthy: true},
+	}
+
+	lc := NewLeastConnectionsAlgorithm()
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[lc.SelectBackend(backends).Address] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected ties to rotate across all backends, got %v", seen)
+	}
+}
+
+func TestAlgorithms_SkipDrainingBackends(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true, Draining: true},
+		{Address: "server2:8082", Healthy: true},
+	}
+
+	algorithms := map[string]Algorithm{
+		"round_robin":          NewRoundRobinAlgorithm(),
+		"weighted_round_robin": NewWeightedRoundRobinAlgorithm(),
+		"least_connections":    NewLeastConnectionsAlgorithm(),
+		"p2c":                  NewPowerOfTwoChoicesAlgorithm(),
+	}
+	for name, algorithm := range algorithms {
+		for i := 0; i < 4; i++ {
+			backend := algorithm.SelectBackend(backends)
+			if backend == nil || backend.Address != "server2:8082" {
+				t.Errorf("%s: expected server2:8082, got %v", name, backend)
+			}
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesAlgorithm_PrefersLessLoaded(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true, ActiveConnections: 10},
+		{Address: "server2:8082", Healthy: true},
+		{Address: "server3:8083", Healthy: false},
+	}
+
+	// With two available backends both are always compared
+	p2c := NewPowerOfTwoChoicesAlgorithm()
+	for i := 0; i < 20; i++ {
+		if backend := p2c.SelectBackend(backends); backend == nil || backend.Address != "server2:8082" {
+			t.Fatalf("Expected server2:8082, got %v", backend)
+		}
+	}
+
+	if backend := p2c.SelectBackend(backends[2:]); backend != nil {
+		t.Errorf("Expected nil without available backends, got %v", backend)
+	}
+}
+
+func TestWeightedRoundRobinAlgorithm_SlowStart(t *testing.T) {
+	backends := []Backend{
+		{Address: "server1:8081", Healthy: true, Weight: 4},
+		{Address: "server2:8082", Healthy: true, Weight: 4, ramp: 0.25},
+	}
+
+	wrr := NewWeightedRoundRobinAlgorithm()
+	counts := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		counts[wrr.SelectBackend(backends).Address]++
+	}
+	if counts["server1:8081"] != 40 || counts["server2:8082"] != 10 {
+		t.Errorf("Expected a 4:1 split while slow starting, got %v", counts)
+	}
+}
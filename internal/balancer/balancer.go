@@ -1,20 +1,104 @@
 package balancer
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
 	"net"
+	"sync"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+	"l4-load-balancer/internal/tracing"
+	"l4-load-balancer/pkg/netutil"
 )
 
+// dialTimeout is the default bound on connecting to a backend
+const dialTimeout = 5 * time.Second
+
 // LoadBalancer represents the main load balancer
 type LoadBalancer struct {
-	listenAddr string
-	backends   []Backend
-	algorithm  Algorithm
+	listenAddr  string
+	defaultPool *Pool
+	pools       map[string]*Pool
+	splits      map[string]*Split
+	router      Router
+	access      AccessController
+	limiter     ConnLimiter
+	accessLog   *slog.Logger
+	tracer      *tracing.Tracer
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+
+	statsMu      sync.Mutex
+	closeReasons map[CloseReason]uint64
 }
 
 // Backend represents a backend server
 type Backend struct {
 	Address string
 	Healthy bool
+
+	// TLSConfig, when set, makes the proxy originate TLS to the backend
+	TLSConfig *tls.Config
+
+	// Weight is the backend's relative share for weighted algorithms;
+	// values below 1 count as 1
+	Weight int
+
+	// Priority is the backend's priority group; lower values are preferred
+	Priority int
+
+	// Zone is the availability zone the backend runs in
+	Zone string
+
+	// Draining backends keep their existing connections but receive no
+	// new ones
+	Draining bool
+
+	// ActiveConnections is the number of connections currently proxied to
+	// the backend, filled in by the pool
+	ActiveConnections int64
+
+	// HealthySince is when the backend last became healthy
+	HealthySince time.Time
+
+	// ramp is the fraction of its weight the backend currently gets while
+	// slow starting; zero means full weight
+	ramp float64
+
+	// tripped is set while the backend's circuit breaker rejects
+	// connections
+	tripped bool
+
+	// trial identifies a connection admitted by a half-open circuit
+	// breaker; zero for regular connections
+	trial uint64
+}
+
+// weight returns the backend's weight, at least 1
+func (b *Backend) weight() int {
+	if b.Weight < 1 {
+		return 1
+	}
+	return b.Weight
+}
+
+// EffectiveWeight returns the backend's weight scaled down while it is
+// slow starting
+func (b *Backend) EffectiveWeight() float64 {
+	if b.ramp > 0 && b.ramp < 1 {
+		return float64(b.weight()) * b.ramp
+	}
+	return float64(b.weight())
+}
+
+// available reports whether the backend may receive new connections
+func (b *Backend) available() bool {
+	return b.Healthy && !b.Draining && !b.tripped
 }
 
 // Algorithm interface for load balancing algorithms
@@ -22,38 +106,426 @@ type Algorithm interface {
 	SelectBackend(backends []Backend) *Backend
 }
 
+// Router picks the pool for a new connection. It may consume bytes from the
+// connection as long as the returned connection replays them.
+type Router interface {
+	Route(conn net.Conn) (string, net.Conn, error)
+}
+
+// AccessController decides whether a newly accepted client may connect
+type AccessController interface {
+	Allowed(addr net.Addr) bool
+}
+
+// ConnLimiter admits new connections subject to rate and concurrency limits.
+// Admit applies the listener-wide limit in the accept loop, before the
+// connection gets a goroutine; Acquire applies the per-client limits. The
+// returned release functions are called when the connection closes.
+type ConnLimiter interface {
+	Admit() (func(), error)
+	Acquire(addr net.Addr) (func(), error)
+}
+
 // NewLoadBalancer creates a new load balancer instance
 func NewLoadBalancer(listenAddr string, backends []Backend, algorithm Algorithm) *LoadBalancer {
+	defaultPool := NewStaticPool(DefaultPoolName, algorithm, backends)
 	return &LoadBalancer{
-		listenAddr: listenAddr,
-		backends:   backends,
-		algorithm:  algorithm,
+		listenAddr:   listenAddr,
+		defaultPool:  defaultPool,
+		pools:        map[string]*Pool{DefaultPoolName: defaultPool},
+		splits:       make(map[string]*Split),
+		closeReasons: make(map[CloseReason]uint64),
 	}
 }
 
+// DefaultPool returns the pool built from the load balancer's own backends
+func (lb *LoadBalancer) DefaultPool() *Pool {
+	return lb.defaultPool
+}
+
+// SetBackendSource makes the default pool fetch the current backends from
+// source for every new connection instead of using the static list
+func (lb *LoadBalancer) SetBackendSource(source func() []Backend) {
+	lb.defaultPool.source = source
+}
+
+// SetDefaultPool replaces the default pool
+func (lb *LoadBalancer) SetDefaultPool(pool *Pool) {
+	lb.defaultPool = pool
+	lb.pools[pool.Name] = pool
+}
+
+// AddPool registers an additional pool that a Router can send connections to
+func (lb *LoadBalancer) AddPool(pool *Pool) {
+	lb.pools[pool.Name] = pool
+}
+
+// AddSplit divides the connections routed to the split's pool between its
+// variant pools
+func (lb *LoadBalancer) AddSplit(split *Split) {
+	lb.splits[split.Pool] = split
+}
+
+// SetRouter installs a router that dispatches connections between pools.
+// Without a router every connection goes to the default pool.
+func (lb *LoadBalancer) SetRouter(router Router) {
+	lb.router = router
+}
+
+// SetAccessController installs a check run on every accepted connection
+// before any other processing; rejected connections are closed immediately
+func (lb *LoadBalancer) SetAccessController(access AccessController) {
+	lb.access = access
+}
+
+// SetConnLimiter installs limits on new and concurrent connections
+func (lb *LoadBalancer) SetConnLimiter(limiter ConnLimiter) {
+	lb.limiter = limiter
+}
+
+// SetAccessLog writes a record for every connection handled to logger
+func (lb *LoadBalancer) SetAccessLog(logger *slog.Logger) {
+	lb.accessLog = logger
+}
+
+// SetTracer records a trace of every connection handled with tracer
+func (lb *LoadBalancer) SetTracer(tracer *tracing.Tracer) {
+	lb.tracer = tracer
+}
+
 // Start starts the load balancer server
 func (lb *LoadBalancer) Start() error {
-	// TODO: Implement L4 load balancing logic
 	listener, err := net.Listen("tcp", lb.listenAddr)
 	if err != nil {
 		return err
 	}
+	return lb.Serve(listener)
+}
+
+// Serve accepts connections on listener until Close is called
+func (lb *LoadBalancer) Serve(listener net.Listener) error {
+	lb.mu.Lock()
+	if lb.closed {
+		lb.mu.Unlock()
+		listener.Close()
+		return ErrClosed
+	}
+	lb.listener = listener
+	lb.mu.Unlock()
 	defer listener.Close()
 
+	name := lb.name()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if lb.isClosed() {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			continue
 		}
 
-		go lb.handleConnection(conn)
+		connectionsAccepted.With(name).Inc()
+		span := lb.tracer.Start("connection", tracing.KindServer,
+			tracing.String("client.address", conn.RemoteAddr().String()),
+			tracing.String("lb.listener", name),
+		)
+
+		if lb.access != nil {
+			allowed := lb.access.Allowed(conn.RemoteAddr())
+			span.Child("acl", tracing.KindInternal, tracing.Bool("acl.allowed", allowed)).End()
+			if !allowed {
+				conn.Close()
+				connectionsRejected.With(name, "acl").Inc()
+				lb.finish(&accessRecord{start: time.Now(), client: conn.RemoteAddr(), span: span}, CloseDenied)
+				continue
+			}
+		}
+
+		var release func()
+		if lb.limiter != nil {
+			release, err = lb.limiter.Admit()
+			if err != nil {
+				reset(conn)
+				connectionsRejected.With(name, "limit").Inc()
+				lb.finish(&accessRecord{start: time.Now(), client: conn.RemoteAddr(), span: span}, CloseRejected)
+				continue
+			}
+		}
+
+		go func() {
+			if release != nil {
+				defer release()
+			}
+			lb.handleConnection(conn, span)
+		}()
+	}
+}
+
+// Addr returns the address the load balancer is listening on, or nil if it
+// has not started yet
+func (lb *LoadBalancer) Addr() net.Addr {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.listener == nil {
+		return nil
+	}
+	return lb.listener.Addr()
+}
+
+// Close stops accepting new connections
+func (lb *LoadBalancer) Close() error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.closed = true
+	if lb.listener != nil {
+		return lb.listener.Close()
 	}
+	return nil
+}
+
+// name identifies the listener in metrics
+func (lb *LoadBalancer) name() string {
+	if lb.listenAddr != "" {
+		return lb.listenAddr
+	}
+	if addr := lb.Addr(); addr != nil {
+		return addr.String()
+	}
+	return "unknown"
+}
+
+func (lb *LoadBalancer) isClosed() bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.closed
+}
+
+// route returns the pool for conn and the connection to proxy from
+func (lb *LoadBalancer) route(conn net.Conn) (*Pool, net.Conn, error) {
+	if lb.router == nil {
+		return lb.defaultPool, conn, nil
+	}
+
+	name, routed, err := lb.router.Route(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pool, ok := lb.pools[name]; ok {
+		return pool, routed, nil
+	}
+	slog.Warn("Unknown pool, using default pool", "pool", name, "client", conn.RemoteAddr().String())
+	return lb.defaultPool, routed, nil
+}
+
+// split sends the connection to one of the variants if the pool it was
+// routed to is split, and returns a function recording whether the
+// connection reached a backend
+func (lb *LoadBalancer) split(pool *Pool, client net.Addr) (*Pool, func(failed bool)) {
+	split, ok := lb.splits[pool.Name]
+	if !ok {
+		return pool, nil
+	}
+	name := split.Choose(client)
+	variant, ok := lb.pools[name]
+	if !ok {
+		slog.Warn("Unknown pool in split", "split", split.Name, "pool", name, "fallback", pool.Name)
+		return pool, nil
+	}
+	return variant, func(failed bool) { split.record(name, failed) }
 }
 
 // handleConnection handles incoming connections
-func (lb *LoadBalancer) handleConnection(conn net.Conn) {
+func (lb *LoadBalancer) handleConnection(conn net.Conn, span *tracing.Span) {
 	defer conn.Close()
+	name := lb.name()
+	access := accessRecord{start: time.Now(), client: conn.RemoteAddr(), span: span}
+
+	if lb.limiter != nil {
+		release, err := lb.limiter.Acquire(conn.RemoteAddr())
+		limitSpan := span.Child("rate_limit", tracing.KindInternal, tracing.Bool("rate_limit.allowed", err == nil))
+		limitSpan.SetError(err)
+		limitSpan.End()
+		if err != nil {
+			reset(conn)
+			connectionsRejected.With(name, "limit").Inc()
+			lb.finish(&access, CloseRejected)
+			return
+		}
+		defer release()
+	}
+
+	active := connectionsActive.With(name)
+	active.Inc()
+	defer active.Dec()
+
+	routeSpan := span.Child("route", tracing.KindInternal)
+	pool, client, err := lb.route(conn)
+	if err == nil {
+		routeSpan.SetAttributes(tracing.String("lb.pool", pool.Name))
+	}
+	routeSpan.SetError(err)
+	routeSpan.End()
+	if err != nil {
+		slog.Warn("Failed to route connection", "client", conn.RemoteAddr().String(), "error", err)
+		lb.finish(&access, CloseRouteError)
+		return
+	}
+	pool, record := lb.split(pool, conn.RemoteAddr())
+	access.pool = pool.Name
+	timeouts := pool.Timeouts()
+	setKeepAlive(conn, timeouts.KeepAlive)
+
+	session := newSession(client, nil, timeouts)
+	backend, backendConn, attempts, err := pool.connect(client.RemoteAddr(), session, span)
+	access.retries = max(attempts-1, 0)
+	if backend != nil {
+		access.backend = backend.Address
+	}
+	if record != nil {
+		record(err != nil)
+	}
+	if errors.Is(err, ErrNoBackend) {
+		slog.Warn("No healthy backend available", "pool", pool.Name, "client", conn.RemoteAddr().String())
+		lb.finish(&access, CloseNoBackend)
+		return
+	}
+	if errors.Is(err, ErrPoolExhausted) {
+		slog.Warn("Backend connection pool exhausted", "pool", pool.Name, "backend", access.backend, "client", conn.RemoteAddr().String())
+		lb.finish(&access, ClosePoolExhausted)
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to connect to backend", "pool", pool.Name, "error", err)
+		lb.finish(&access, CloseDialError)
+		return
+	}
+	defer backendConn.Close()
+
+	report := pool.observe(backend)
+	backendActive := backendConnectionsActive.With(pool.Name, backend.Address)
+	backendActive.Inc()
+	if pool.mirror != nil {
+		session.mirror = pool.mirror.start(pool.Name, timeouts)
+	}
+	if pool.bandwidth != nil {
+		session.bandwidth = pool.bandwidth.Open(netutil.AddrIP(client.RemoteAddr()), backend.Address)
+		defer session.bandwidth.Close()
+		for _, scope := range session.bandwidth.Untracked() {
+			bandwidthUntracked.With(pool.Name, string(scope)).Inc()
+		}
+		session.onThrottle = func(dir ratelimit.Direction, scope ratelimit.BandwidthScope, wait time.Duration) {
+			backendThrottled.With(pool.Name, backend.Address, dir.String(), string(scope)).Add(wait.Seconds())
+		}
+	}
+	result := session.run()
+	backendActive.Dec()
+	report(result)
+
+	backendBytes.With(pool.Name, backend.Address, "in").Add(float64(result.BytesIn))
+	backendBytes.With(pool.Name, backend.Address, "out").Add(float64(result.BytesOut))
+	backendConnectionDuration.With(pool.Name, backend.Address).Observe(result.Duration.Seconds())
+	pool.untrackSession(backend.Address, session)
+	access.bytesIn, access.bytesOut = result.BytesIn, result.BytesOut
+	lb.finish(&access, result.Reason)
+}
+
+// accessRecord collects what the access log reports about a connection
+type accessRecord struct {
+	start    time.Time
+	client   net.Addr
+	pool     string
+	backend  string
+	retries  int
+	bytesIn  int64
+	bytesOut int64
+	span     *tracing.Span
+}
+
+// finish records how a connection handled by the load balancer ended and
+// writes its access log record
+func (lb *LoadBalancer) finish(access *accessRecord, reason CloseReason) {
+	lb.recordClose(reason)
+	lb.endSpan(access, reason)
+	if lb.accessLog == nil {
+		return
+	}
+	lb.accessLog.LogAttrs(context.Background(), slog.LevelInfo, "connection",
+		slog.String("client", access.client.String()),
+		slog.String("listener", lb.name()),
+		slog.String("pool", access.pool),
+		slog.String("backend", access.backend),
+		slog.Time("start", access.start),
+		slog.Duration("duration", time.Since(access.start)),
+		slog.Int64("bytes_in", access.bytesIn),
+		slog.Int64("bytes_out", access.bytesOut),
+		slog.String("reason", string(reason)),
+		slog.Int("retries", access.retries),
+	)
+}
+
+// endSpan completes the connection's trace span with its outcome
+func (lb *LoadBalancer) endSpan(access *accessRecord, reason CloseReason) {
+	span := access.span
+	if span == nil {
+		return
+	}
+	span.SetAttributes(
+		tracing.String("lb.pool", access.pool),
+		tracing.String("lb.backend", access.backend),
+		tracing.Int("lb.retries", access.retries),
+		tracing.Int64("lb.bytes_in", access.bytesIn),
+		tracing.Int64("lb.bytes_out", access.bytesOut),
+		tracing.String("lb.close_reason", string(reason)),
+	)
+	switch reason {
+	case CloseRouteError, CloseNoBackend, CloseDialError, CloseError:
+		span.SetError(errors.New(string(reason)))
+	}
+	span.End()
+}
+
+// recordClose counts a finished or refused connection by reason
+func (lb *LoadBalancer) recordClose(reason CloseReason) {
+	connectionsClosed.With(lb.name(), string(reason)).Inc()
+
+	lb.statsMu.Lock()
+	lb.closeReasons[reason]++
+	lb.statsMu.Unlock()
+}
+
+// CloseReasons returns how many connections ended for each reason
+func (lb *LoadBalancer) CloseReasons() map[CloseReason]uint64 {
+	lb.statsMu.Lock()
+	defer lb.statsMu.Unlock()
+
+	counts := make(map[CloseReason]uint64, len(lb.closeReasons))
+	for reason, n := range lb.closeReasons {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// Error definitions
+var (
+	ErrClosed        = errors.New("load balancer closed")
+	ErrNoBackend     = errors.New("no healthy backend available")
+	ErrPoolExhausted = errors.New("backend connection pool exhausted")
+)
+
+// DialError reports a failed connection attempt to a backend
+type DialError struct {
+	Backend string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return "dial " + e.Backend + ": " + e.Err.Error()
+}
 
-	// TODO: Select backend using algorithm
-	// TODO: Proxy connection to selected backend
+func (e *DialError) Unwrap() error {
+	return e.Err
 }
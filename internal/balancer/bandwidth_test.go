@@ -0,0 +1,68 @@
+package balancer
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+)
+
+func TestLoadBalancer_Bandwidth(t *testing.T) {
+	const size = 4000
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.Write(make([]byte, size))
+				conn.Close()
+			}()
+		}
+	}()
+
+	address := backendLn.Addr().String()
+	lb := NewLoadBalancer("", []Backend{{Address: address, Healthy: true}}, NewRoundRobinAlgorithm())
+	lb.DefaultPool().SetBandwidth(ratelimit.NewThrottle(ratelimit.BandwidthConfig{
+		PerConnection: ratelimit.BandwidthLimits{Out: ratelimit.ByteRate{Rate: 10000, Burst: 1000}},
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil || n != size {
+		t.Fatalf("Expected %d bytes, got %d: %v", size, n, err)
+	}
+
+	// The first 1000 bytes are the burst; the rest arrive at 10000 B/s
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected the download to take at least 250ms, took %v", elapsed)
+	}
+	throttled := backendThrottled.With(DefaultPoolName, address, "out", "connection").Value()
+	if throttled < 0.25 {
+		t.Errorf("Expected at least 0.25s of throttling to be recorded, got %v", throttled)
+	}
+	if v := backendThrottled.With(DefaultPoolName, address, "in", "connection").Value(); v != 0 {
+		t.Errorf("Expected no throttling of the client's bytes, got %v", v)
+	}
+}
@@ -0,0 +1,217 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a backend's circuit breaker
+type BreakerState string
+
+// Circuit breaker states
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerFailureThreshold   = 5
+	DefaultBreakerOpenDuration       = 10 * time.Second
+	DefaultBreakerHalfOpenTrials     = 1
+	DefaultBreakerEarlyFailureWindow = time.Second
+)
+
+// BreakerPolicy configures the circuit breakers of a pool's backends
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures that opens
+	// the breaker
+	FailureThreshold int
+
+	// OpenDuration is how long an open breaker rejects the backend before
+	// admitting trial connections
+	OpenDuration time.Duration
+
+	// HalfOpenTrials is how many trial connections may be in flight while
+	// half-open, and how many must succeed to close the breaker
+	HalfOpenTrials int
+
+	// EarlyFailureWindow counts a connection the backend closes within this
+	// time without sending anything as a failure; negative disables it
+	EarlyFailureWindow time.Duration
+}
+
+// withDefaults fills in unset values
+func (p BreakerPolicy) withDefaults() BreakerPolicy {
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if p.OpenDuration <= 0 {
+		p.OpenDuration = DefaultBreakerOpenDuration
+	}
+	if p.HalfOpenTrials <= 0 {
+		p.HalfOpenTrials = DefaultBreakerHalfOpenTrials
+	}
+	if p.EarlyFailureWindow == 0 {
+		p.EarlyFailureWindow = DefaultBreakerEarlyFailureWindow
+	}
+	return p
+}
+
+// CircuitBreaker stops sending connections to a failing backend. It opens
+// after FailureThreshold consecutive failures, rejects the backend for
+// OpenDuration, then admits HalfOpenTrials trial connections and closes
+// once they all succeed; any failed trial opens it again.
+type CircuitBreaker struct {
+	policy   BreakerPolicy
+	onChange func(BreakerState)
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+
+	// round identifies the current half-open period so that outcomes of
+	// trials from an earlier one are ignored
+	round uint64
+}
+
+// NewCircuitBreaker creates a closed circuit breaker. onChange, if set, is
+// called on every state change.
+func NewCircuitBreaker(policy BreakerPolicy, onChange func(BreakerState)) *CircuitBreaker {
+	return &CircuitBreaker{
+		policy:   policy.withDefaults(),
+		onChange: onChange,
+		state:    BreakerClosed,
+	}
+}
+
+// State returns the breaker's current state
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+// allows reports whether a connection could be sent to the backend now
+func (b *CircuitBreaker) allows(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(now)
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.trials < b.policy.HalfOpenTrials
+	}
+	return true
+}
+
+// acquire admits a connection to the backend. For half-open trials it
+// returns a non-zero trial token that must be passed back with the outcome.
+func (b *CircuitBreaker) acquire(now time.Time) (trial uint64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(now)
+	switch b.state {
+	case BreakerOpen:
+		return 0, false
+	case BreakerHalfOpen:
+		if b.trials >= b.policy.HalfOpenTrials {
+			return 0, false
+		}
+		b.trials++
+		return b.round, true
+	}
+	return 0, true
+}
+
+// success records a connection that worked
+func (b *CircuitBreaker) success(trial uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures = 0
+	case BreakerHalfOpen:
+		if trial != b.round {
+			return
+		}
+		b.trials--
+		b.successes++
+		if b.successes >= b.policy.HalfOpenTrials {
+			b.failures = 0
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// release gives back a half-open trial slot without recording an outcome,
+// for a connection abandoned before it reached the backend
+func (b *CircuitBreaker) release(trial uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && trial == b.round {
+		b.trials--
+	}
+}
+
+// failure records a connection that failed
+func (b *CircuitBreaker) failure(trial uint64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.failures >= b.policy.FailureThreshold {
+			b.open(now)
+		}
+	case BreakerHalfOpen:
+		if trial == b.round {
+			b.open(now)
+		}
+	}
+}
+
+// Reset closes the breaker and forgets past failures
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.setState(BreakerClosed)
+}
+
+// refresh moves an open breaker to half-open once OpenDuration has passed.
+// Callers hold b.mu.
+func (b *CircuitBreaker) refresh(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.policy.OpenDuration {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+// open trips the breaker. Callers hold b.mu.
+func (b *CircuitBreaker) open(now time.Time) {
+	b.openedAt = now
+	b.setState(BreakerOpen)
+}
+
+// setState changes state, resetting the half-open counters. Callers hold
+// b.mu.
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.trials = 0
+	b.successes = 0
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if state == BreakerHalfOpen {
+		b.round++
+	}
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
@@ -0,0 +1,186 @@
+package balancer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/metrics"
+)
+
+func TestCircuitBreaker_StateMachine(t *testing.T) {
+	var changes []BreakerState
+	br := NewCircuitBreaker(BreakerPolicy{
+		FailureThreshold: 3,
+		OpenDuration:     time.Minute,
+		HalfOpenTrials:   2,
+	}, func(state BreakerState) { changes = append(changes, state) })
+	now := time.Now()
+
+	// A success resets the consecutive failure count
+	br.failure(0, now)
+	br.failure(0, now)
+	br.success(0)
+	br.failure(0, now)
+	br.failure(0, now)
+	if !br.allows(now) {
+		t.Fatal("Expected breaker to stay closed below the threshold")
+	}
+	br.failure(0, now)
+	if br.allows(now) || br.State() != BreakerOpen {
+		t.Fatalf("Expected breaker to open, state %s", br.State())
+	}
+
+	// After the open duration a limited number of trials are admitted
+	later := now.Add(time.Minute)
+	first, ok1 := br.acquire(later)
+	second, ok2 := br.acquire(later)
+	if !ok1 || !ok2 || first == 0 || second == 0 {
+		t.Fatal("Expected two half-open trials")
+	}
+	if _, ok := br.acquire(later); ok {
+		t.Error("Expected a third trial to be rejected")
+	}
+
+	// A failed trial opens the breaker again
+	br.failure(first, later)
+	if br.allows(later) {
+		t.Error("Expected failed trial to reopen the breaker")
+	}
+
+	// Stale outcomes from the earlier trial don't count in the next round
+	evenLater := later.Add(time.Minute)
+	trial, _ := br.acquire(evenLater)
+	br.success(second)
+	br.success(trial)
+	if br.State() != BreakerHalfOpen {
+		t.Fatalf("Expected breaker to stay half-open after one success, state %s", br.State())
+	}
+	trial, _ = br.acquire(evenLater)
+	br.success(trial)
+	if br.State() != BreakerClosed {
+		t.Fatalf("Expected breaker to close, state %s", br.State())
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected transitions %v, got %v", expected, changes)
+			break
+		}
+	}
+}
+
+func TestPool_CircuitBreakerSkipsFailingBackend(t *testing.T) {
+	live, dead := liveAddress(t), deadAddress(t)
+	pool := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{
+		{Address: live, Healthy: true},
+		{Address: dead, Healthy: true},
+	})
+	pool.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 2, OpenDuration: time.Hour})
+
+	failures := 0
+	for i := 0; i < 10; i++ {
+		backend, conn, _, err := pool.connect(nil, nil, nil)
+		if err != nil {
+			failures++
+			continue
+		}
+		conn.Close()
+		if backend.Address != live {
+			t.Errorf("Unexpected backend %s", backend.Address)
+		}
+	}
+	if failures != 2 {
+		t.Errorf("Expected the dead backend to fail twice before its breaker opened, got %d", failures)
+	}
+	if state := pool.BreakerState(dead); state != BreakerOpen {
+		t.Errorf("Expected open breaker for dead backend, got %s", state)
+	}
+	if state := pool.BreakerState(live); state != BreakerClosed {
+		t.Errorf("Expected closed breaker for live backend, got %s", state)
+	}
+
+	if !pool.ResetBreaker(dead) || pool.BreakerState(dead) != BreakerClosed {
+		t.Error("Expected reset to close the breaker")
+	}
+}
+
+func TestPool_CircuitBreakerSkipIsNotAnAttempt(t *testing.T) {
+	live, dead := liveAddress(t), deadAddress(t)
+	pool := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{
+		{Address: live, Healthy: true},
+		{Address: dead, Healthy: true},
+	})
+	pool.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 1, OpenDuration: time.Hour})
+
+	// Once the dead backend's breaker is open, skipping it leaves the
+	// connection its single attempt on the live backend
+	for i := 0; i < 6; i++ {
+		backend, conn, attempts, err := pool.connect(nil, nil, nil)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		if backend.Address != live || attempts != 1 {
+			t.Errorf("Expected %s on attempt 1, got %s on attempt %d", live, backend.Address, attempts)
+		}
+	}
+}
+
+func TestPool_ForgetBackend(t *testing.T) {
+	dead := deadAddress(t)
+	pool := NewStaticPool("forget", NewRoundRobinAlgorithm(), []Backend{{Address: dead, Healthy: true}})
+	pool.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 1, OpenDuration: time.Hour})
+	series := func(address string) bool {
+		var out bytes.Buffer
+		metrics.DefaultRegistry.WriteText(&out)
+		for _, line := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(line, "lb_circuit_breaker_") && strings.Contains(line, `backend="`+address+`"`) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if _, _, _, err := pool.connect(nil, nil, nil); err == nil {
+		t.Fatal("Expected the dead backend to fail")
+	}
+	if pool.BreakerState(dead) != BreakerOpen || !series(dead) {
+		t.Fatal("Expected an open breaker with metric series")
+	}
+
+	pool.ForgetBackend(dead)
+	if pool.BreakerState(dead) != BreakerClosed || series(dead) {
+		t.Error("Expected the breaker and its series to be forgotten")
+	}
+
+	// Looking up a backend the pool never selected doesn't create a breaker
+	pool.BreakerState("unknown:1")
+	pool.ResetBreaker("unknown:1")
+	if series("unknown:1") {
+		t.Error("Expected no breaker for an unknown backend")
+	}
+}
+
+func TestPool_ObserveEarlyFailures(t *testing.T) {
+	backend := &Backend{Address: "server1:8081", Healthy: true}
+	pool := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{*backend})
+	pool.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 1, EarlyFailureWindow: time.Hour})
+	pool.breaker(backend.Address) // created when connect selects the backend
+
+	// Data from the backend means it is serving
+	pool.observe(backend)(sessionResult{Reason: CloseBackendClosed, BytesOut: 10})
+	if pool.BreakerState(backend.Address) != BreakerClosed {
+		t.Fatal("Expected a connection with a response to count as a success")
+	}
+
+	pool.observe(backend)(sessionResult{Reason: CloseBackendClosed})
+	if pool.BreakerState(backend.Address) != BreakerOpen {
+		t.Error("Expected an immediate close to count as a failure")
+	}
+}
@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"net/netip"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"l4-load-balancer/pkg/netutil"
+)
+
+// CloseKilled is the close reason of connections terminated through
+// CloseConnection or CloseMatching
+const CloseKilled CloseReason = "killed"
+
+// nextSessionID numbers proxied connections across all pools
+var nextSessionID atomic.Uint64
+
+// ConnectionInfo describes an active proxied connection
+type ConnectionInfo struct {
+	ID          uint64    `json:"id"`
+	Pool        string    `json:"pool"`
+	Client      string    `json:"client"`
+	Backend     string    `json:"backend"`
+	Start       time.Time `json:"start"`
+	AgeSeconds  float64   `json:"age_seconds"`
+	IdleSeconds float64   `json:"idle_seconds"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+}
+
+// ConnectionFilter selects active connections. Zero fields match every
+// connection.
+type ConnectionFilter struct {
+	// Backend is the address of the backend the connection is proxied to
+	Backend string
+	// Client is a prefix the client IP must fall in
+	Client netip.Prefix
+}
+
+// matches reports whether the filter selects s
+func (f ConnectionFilter) matches(s *session) bool {
+	if f.Backend != "" && f.Backend != s.address {
+		return false
+	}
+	if f.Client.IsValid() && !f.Client.Contains(netutil.AddrIP(s.client.RemoteAddr())) {
+		return false
+	}
+	return true
+}
+
+// Connections returns the pool's active connections selected by filter,
+// ordered by ID
+func (p *Pool) Connections(filter ConnectionFilter) []ConnectionInfo {
+	now := time.Now()
+	sessions := p.matchingSessions(filter)
+	infos := make([]ConnectionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, ConnectionInfo{
+			ID:          s.id,
+			Pool:        p.Name,
+			Client:      s.client.RemoteAddr().String(),
+			Backend:     s.address,
+			Start:       s.start,
+			AgeSeconds:  now.Sub(s.start).Seconds(),
+			IdleSeconds: now.Sub(time.Unix(0, s.lastActivity.Load())).Seconds(),
+			BytesIn:     s.bytesIn.Load(),
+			BytesOut:    s.bytesOut.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// CloseConnection forcibly closes the active connection with the given ID
+// and reports whether the pool had it
+func (p *Pool) CloseConnection(id uint64) bool {
+	p.sessionsMu.Lock()
+	var found *session
+	for _, sessions := range p.sessions {
+		for s := range sessions {
+			if s.id == id {
+				found = s
+			}
+		}
+	}
+	p.sessionsMu.Unlock()
+
+	if found == nil {
+		return false
+	}
+	found.abort(CloseKilled)
+	return true
+}
+
+// CloseMatching forcibly closes the active connections selected by filter
+// and returns how many were closed
+func (p *Pool) CloseMatching(filter ConnectionFilter) int {
+	sessions := p.matchingSessions(filter)
+	for _, s := range sessions {
+		s.abort(CloseKilled)
+	}
+	return len(sessions)
+}
+
+// matchingSessions returns the active sessions selected by filter
+func (p *Pool) matchingSessions(filter ConnectionFilter) []*session {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	var matched []*session
+	for _, sessions := range p.sessions {
+		for s := range sessions {
+			if filter.matches(s) {
+				matched = append(matched, s)
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,109 @@
+package balancer
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// dialFrom connects to address from the given local IP
+func dialFrom(t *testing.T, ip, address string) net.Conn {
+	t.Helper()
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	conn, err := d.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestPool_Connections(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	address := backendLn.Addr().String()
+	lb := NewLoadBalancer("", []Backend{{Address: address, Healthy: true}}, NewRoundRobinAlgorithm())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	first := dialFrom(t, "127.0.0.1", listener.Addr().String())
+	second := dialFrom(t, "127.0.0.2", listener.Addr().String())
+	first.Write([]byte("hello"))
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(first, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := lb.DefaultPool()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pool.Connections(ConnectionFilter{})) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connections were never tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	matched := pool.Connections(ConnectionFilter{Client: netip.MustParsePrefix("127.0.0.1/32")})
+	if len(matched) != 1 {
+		t.Fatalf("Expected 1 connection from 127.0.0.1, got %d", len(matched))
+	}
+	info := matched[0]
+	if info.Client != first.LocalAddr().String() || info.Backend != address || info.Pool != DefaultPoolName {
+		t.Errorf("Unexpected connection %+v", info)
+	}
+	if info.BytesIn != 5 || info.BytesOut != 5 || info.AgeSeconds <= 0 {
+		t.Errorf("Expected 5 bytes each way and a positive age, got %+v", info)
+	}
+	if n := len(pool.Connections(ConnectionFilter{Backend: "127.0.0.1:1"})); n != 0 {
+		t.Errorf("Expected no connections to another backend, got %d", n)
+	}
+
+	if !pool.CloseConnection(info.ID) {
+		t.Fatal("Expected the connection to be closed")
+	}
+	if _, err := first.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the client connection to be closed")
+	}
+	for len(pool.Connections(ConnectionFilter{})) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Closed connection was never untracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.CloseConnection(info.ID) {
+		t.Error("Expected a closed connection to be gone")
+	}
+
+	if n := pool.CloseMatching(ConnectionFilter{Backend: address}); n != 1 {
+		t.Errorf("Expected 1 connection closed by backend, got %d", n)
+	}
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the second client connection to be closed")
+	}
+	for lb.CloseReasons()[CloseKilled] != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 killed connections, got %d", lb.CloseReasons()[CloseKilled])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
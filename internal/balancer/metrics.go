@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"l4-load-balancer/internal/metrics"
+)
+
+var (
+	connectionsAccepted = metrics.NewCounterVec(
+		"lb_connections_accepted_total",
+		"Connections accepted by the listener.",
+		"listener")
+	connectionsRejected = metrics.NewCounterVec(
+		"lb_connections_rejected_total",
+		"Connections rejected before proxying, by reason.",
+		"listener", "reason")
+	connectionsActive = metrics.NewGaugeVec(
+		"lb_connections_active",
+		"Connections currently being handled by the listener.",
+		"listener")
+	connectionsClosed = metrics.NewCounterVec(
+		"lb_connections_closed_total",
+		"Connections finished, by close reason.",
+		"listener", "reason")
+
+	backendConnectionsActive = metrics.NewGaugeVec(
+		"lb_backend_connections_active",
+		"Connections currently proxied to the backend.",
+		"pool", "backend")
+	backendBytes = metrics.NewCounterVec(
+		"lb_backend_bytes_total",
+		"Bytes proxied to (in) and from (out) the backend.",
+		"pool", "backend", "direction")
+	backendConnectionDuration = metrics.NewHistogramVec(
+		"lb_backend_connection_duration_seconds",
+		"Duration of connections proxied to the backend.",
+		metrics.DefaultDurationBuckets,
+		"pool", "backend")
+	backendThrottled = metrics.NewCounterVec(
+		"lb_backend_throttled_seconds_total",
+		"Time connections to the backend waited for bandwidth limits, by direction and limit.",
+		"pool", "backend", "direction", "limit")
+	bandwidthUntracked = metrics.NewCounterVec(
+		"lb_bandwidth_untracked_total",
+		"Connections not held to a shared bandwidth limit because its table was full of active clients or backends, by limit.",
+		"pool", "limit")
+	backendDialErrors = metrics.NewCounterVec(
+		"lb_backend_dial_errors_total",
+		"Failed dials to the backend, by error type.",
+		"pool", "backend", "type")
+	backendDialRetries = metrics.NewCounterVec(
+		"lb_backend_dial_retries_total",
+		"Dials retried on another backend after a failure.",
+		"pool")
+	splitConnections = metrics.NewCounterVec(
+		"lb_split_connections_total",
+		"Connections assigned to each variant of a traffic split.",
+		"split", "pool")
+	splitErrors = metrics.NewCounterVec(
+		"lb_split_errors_total",
+		"Connections assigned to a split variant that reached no backend.",
+		"split", "pool")
+	mirrorConnections = metrics.NewCounterVec(
+		"lb_mirror_connections_total",
+		"Connections mirrored to the shadow backend, by outcome.",
+		"pool", "result")
+	mirrorBytes = metrics.NewCounterVec(
+		"lb_mirror_bytes_total",
+		"Client bytes copied to the shadow backend.",
+		"pool")
+	breakerState = metrics.NewGaugeVec(
+		"lb_circuit_breaker_state",
+		"Circuit breaker state of the backend: 0 closed, 1 half-open, 2 open.",
+		"pool", "backend")
+	breakerTransitions = metrics.NewCounterVec(
+		"lb_circuit_breaker_transitions_total",
+		"Circuit breaker state changes, by new state.",
+		"pool", "backend", "state")
+	zoneConnections = metrics.NewCounterVec(
+		"lb_zone_connections_total",
+		"Backends selected for connections in each zone, including selections whose dial failed.",
+		"pool", "zone")
+)
+
+// breakerStateValue encodes a circuit breaker state for the state gauge
+func breakerStateValue(state BreakerState) float64 {
+	switch state {
+	case BreakerHalfOpen:
+		return 1
+	case BreakerOpen:
+		return 2
+	}
+	return 0
+}
+
+// dialErrorType classifies a dial error for metrics
+func dialErrorType(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	default:
+		return "other"
+	}
+}
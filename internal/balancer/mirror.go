@@ -0,0 +1,164 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"l4-load-balancer/pkg/dialer"
+)
+
+// DefaultMirrorBufferSize bounds the bytes buffered for a shadow backend
+// per connection when no size is configured
+const DefaultMirrorBufferSize = 256 * 1024
+
+// mirrorWriteTimeout bounds a single write to the shadow backend
+const mirrorWriteTimeout = 10 * time.Second
+
+// Mirror copies the client-to-backend bytes of a sample of connections to a
+// shadow backend and discards whatever it sends back. Mirroring never slows
+// or fails the primary connection: bytes are buffered up to a limit, and a
+// mirrored stream that falls behind is dropped.
+type Mirror struct {
+	Address   string
+	TLSConfig *tls.Config
+
+	// Dialer opens connections to the shadow backend; nil uses
+	// dialer.Default
+	Dialer dialer.Dialer
+
+	// SamplePercent is the share of connections mirrored
+	SamplePercent float64
+
+	// BufferSize is the most bytes buffered per connection while the
+	// shadow backend catches up
+	BufferSize int
+}
+
+// NewMirror creates a mirror to the shadow backend at address
+func NewMirror(address string, samplePercent float64, bufferSize int) *Mirror {
+	if bufferSize <= 0 {
+		bufferSize = DefaultMirrorBufferSize
+	}
+	return &Mirror{
+		Address:       address,
+		SamplePercent: samplePercent,
+		BufferSize:    bufferSize,
+	}
+}
+
+// start begins mirroring a connection of pool if it is sampled, and
+// returns nil otherwise
+func (m *Mirror) start(pool string, timeouts Timeouts) *mirrorStream {
+	if m.SamplePercent < 100 && rand.Float64()*100 >= m.SamplePercent {
+		return nil
+	}
+	s := &mirrorStream{
+		mirror: m,
+		pool:   pool,
+		wake:   make(chan struct{}, 1),
+	}
+	go s.run(timeouts)
+	return s
+}
+
+// mirrorStream forwards one connection's client bytes to the shadow backend
+type mirrorStream struct {
+	mirror *Mirror
+	pool   string
+	wake   chan struct{}
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+	broken bool
+}
+
+// write queues a copy of p for the shadow backend without blocking. If the
+// buffer is full the stream is dropped, since a stream with gaps is useless
+// to the shadow.
+func (s *mirrorStream) write(p []byte) {
+	s.mu.Lock()
+	if s.broken || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.buf)+len(p) > s.mirror.BufferSize {
+		s.broken = true
+		s.buf = nil
+	} else {
+		s.buf = append(s.buf, p...)
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// close marks the end of the client's data
+func (s *mirrorStream) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *mirrorStream) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail stops accepting data for the stream
+func (s *mirrorStream) fail() {
+	s.mu.Lock()
+	s.broken = true
+	s.buf = nil
+	s.mu.Unlock()
+}
+
+// run dials the shadow backend and writes the queued bytes to it until the
+// client is done or the stream is dropped. The dial is always bounded so an
+// unreachable shadow can't pin a goroutine per mirrored connection.
+func (s *mirrorStream) run(timeouts Timeouts) {
+	if timeouts.Connect <= 0 {
+		timeouts.Connect = dialTimeout
+	}
+	conn, err := dialBackend(s.mirror.Dialer, &Backend{Address: s.mirror.Address, TLSConfig: s.mirror.TLSConfig}, timeouts)
+	if err != nil {
+		s.fail()
+		mirrorConnections.With(s.pool, "dial_error").Inc()
+		return
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	for range s.wake {
+		s.mu.Lock()
+		data, closed, broken := s.buf, s.closed, s.broken
+		s.buf = nil
+		s.mu.Unlock()
+
+		if broken {
+			mirrorConnections.With(s.pool, "dropped").Inc()
+			return
+		}
+		if len(data) > 0 {
+			conn.SetWriteDeadline(time.Now().Add(mirrorWriteTimeout))
+			if _, err := conn.Write(data); err != nil {
+				s.fail()
+				mirrorConnections.With(s.pool, "write_error").Inc()
+				return
+			}
+			mirrorBytes.With(s.pool).Add(float64(len(data)))
+		}
+		if closed {
+			if cw, ok := conn.(closeWriter); ok {
+				cw.CloseWrite()
+			}
+			mirrorConnections.With(s.pool, "completed").Inc()
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMirror_CopiesClientBytes(t *testing.T) {
+	// The primary backend echoes; the shadow records what it receives and
+	// answers with noise that must not reach the client
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	go func() {
+		conn, err := primary.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	shadow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := shadow.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("shadow noise"))
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	lb := NewLoadBalancer("", []Backend{{Address: primary.Addr().String(), Healthy: true}}, NewRoundRobinAlgorithm())
+	lb.DefaultPool().SetMirror(NewMirror(shadow.Addr().String(), 100, 0))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := []byte("hello primary and shadow")
+	conn.Write(payload)
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	echoed, _ := io.ReadAll(conn)
+	if !bytes.Equal(echoed, payload) {
+		t.Errorf("Expected client to get only the primary's echo, got %q", echoed)
+	}
+
+	select {
+	case data := <-received:
+		if !bytes.Equal(data, payload) {
+			t.Errorf("Expected shadow to receive %q, got %q", payload, data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shadow never received the mirrored bytes")
+	}
+}
+
+func TestMirror_DropsOnBackpressure(t *testing.T) {
+	// Nothing accepts on the shadow address quickly enough to drain the
+	// buffer, so writing past its size drops the stream
+	m := NewMirror(deadAddress(t), 100, 16)
+	s := m.start("test", DefaultTimeouts())
+	s.write(make([]byte, 10))
+	s.write(make([]byte, 10))
+
+	s.mu.Lock()
+	broken, buffered := s.broken, len(s.buf)
+	s.mu.Unlock()
+	if !broken || buffered != 0 {
+		t.Errorf("Expected stream to be dropped, broken=%v buffered=%d", broken, buffered)
+	}
+	s.close()
+
+	if s := NewMirror(deadAddress(t), 0, 0).start("test", DefaultTimeouts()); s != nil {
+		t.Error("Expected a 0% sample to mirror nothing")
+	}
+}
+
+// deadlineDialer fails every dial, reporting whether it had a deadline
+type deadlineDialer struct {
+	deadlines chan bool
+}
+
+func (d deadlineDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	_, ok := ctx.Deadline()
+	d.deadlines <- ok
+	return nil, errors.New("unreachable")
+}
+
+func TestMirror_BoundsDial(t *testing.T) {
+	d := deadlineDialer{deadlines: make(chan bool, 1)}
+	m := NewMirror("192.0.2.1:80", 100, 0)
+	m.Dialer = d
+
+	// Without a connect timeout the mirror still bounds its dial
+	stream := m.start(DefaultPoolName, Timeouts{})
+	select {
+	case ok := <-d.deadlines:
+		if !ok {
+			t.Error("Expected the mirror dial to have a deadline")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Mirror never dialed")
+	}
+	stream.close()
+}
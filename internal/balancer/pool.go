@@ -0,0 +1,569 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+	"l4-load-balancer/internal/tracing"
+	"l4-load-balancer/pkg/dialer"
+	"l4-load-balancer/pkg/netutil"
+	"l4-load-balancer/pkg/pool"
+)
+
+// DefaultPoolName is the name of the pool built from the load balancer's
+// own backends
+const DefaultPoolName = "default"
+
+// Pool is a named group of backends with its own selection algorithm
+type Pool struct {
+	Name      string
+	algorithm Algorithm
+	algName   string
+	source    func() []Backend
+	timeouts  Timeouts
+	retry     RetryPolicy
+	budget    *retryBudget
+	slowStart SlowStart
+	affinity  *AffinityTable
+	mirror    *Mirror
+	dialer    dialer.Dialer
+	connPools func(address string) *pool.ConnectionPool
+	bandwidth *ratelimit.Throttle
+
+	breakerPolicy *BreakerPolicy
+	breakersMu    sync.Mutex
+	breakers      map[string]*CircuitBreaker
+
+	sessionsMu sync.Mutex
+	sessions   map[string]map[*session]struct{}
+
+	// forgotten holds removed backends whose metric series are deleted
+	// once their last session ends
+	forgotten map[string]bool
+}
+
+// NewPool creates a pool that selects among the backends returned by source
+func NewPool(name string, algorithm Algorithm, source func() []Backend) *Pool {
+	return &Pool{
+		Name:      name,
+		algorithm: algorithm,
+		algName:   algorithmName(algorithm),
+		source:    source,
+		timeouts:  DefaultTimeouts(),
+		sessions:  make(map[string]map[*session]struct{}),
+	}
+}
+
+// NewStaticPool creates a pool over a fixed list of backends
+func NewStaticPool(name string, algorithm Algorithm, backends []Backend) *Pool {
+	return NewPool(name, algorithm, func() []Backend { return backends })
+}
+
+// SetTimeouts sets the connect, idle, lifetime and keepalive settings for
+// connections proxied through the pool
+func (p *Pool) SetTimeouts(timeouts Timeouts) {
+	p.timeouts = timeouts
+}
+
+// SetRetryPolicy enables retrying failed dials on other backends
+func (p *Pool) SetRetryPolicy(policy RetryPolicy) {
+	p.retry = policy
+	p.budget = newRetryBudget(policy)
+}
+
+// SetSlowStart ramps up the weight of backends that recently became healthy
+func (p *Pool) SetSlowStart(slowStart SlowStart) {
+	p.slowStart = slowStart
+}
+
+// SetAffinity makes clients stick to the backend they were last sent to for
+// as long as the table remembers them and the backend is available
+func (p *Pool) SetAffinity(table *AffinityTable) {
+	p.affinity = table
+}
+
+// SetMirror copies the client bytes of a sample of the pool's connections
+// to a shadow backend
+func (p *Pool) SetMirror(mirror *Mirror) {
+	p.mirror = mirror
+}
+
+// SetDialer sets the dialer used to connect to the pool's backends
+func (p *Pool) SetDialer(d dialer.Dialer) {
+	p.dialer = d
+}
+
+// BackendDialer returns the dialer used for plaintext connections to the
+// pool's backends, with the pool's keepalive settings applied. Connection
+// pools feeding the pool should dial with it so pooled connections match
+// directly dialed ones.
+func (p *Pool) BackendDialer() dialer.Dialer {
+	return withKeepAlive(p.dialer, p.timeouts.KeepAlive)
+}
+
+// SetConnectionPools has the pool take plain TCP backend connections from
+// the connection pool lookup returns for a backend, if any, so that clients
+// can use connections opened ahead of time
+func (p *Pool) SetConnectionPools(lookup func(address string) *pool.ConnectionPool) {
+	p.connPools = lookup
+}
+
+// SetBandwidth limits the byte rate of connections proxied through the
+// pool
+func (p *Pool) SetBandwidth(throttle *ratelimit.Throttle) {
+	p.bandwidth = throttle
+}
+
+// SetCircuitBreaker gives each of the pool's backends a circuit breaker
+// driven by dial errors and early connection failures
+func (p *Pool) SetCircuitBreaker(policy BreakerPolicy) {
+	policy = policy.withDefaults()
+	p.breakerPolicy = &policy
+	p.breakers = make(map[string]*CircuitBreaker)
+}
+
+// BreakerState returns the state of the backend's circuit breaker, or ""
+// if the pool has no circuit breakers. A backend that hasn't been selected
+// yet has a closed breaker.
+func (p *Pool) BreakerState(address string) BreakerState {
+	if p.breakerPolicy == nil {
+		return ""
+	}
+	if br := p.existingBreaker(address); br != nil {
+		return br.State()
+	}
+	return BreakerClosed
+}
+
+// ResetBreaker closes the backend's circuit breaker and reports whether the
+// pool has circuit breakers
+func (p *Pool) ResetBreaker(address string) bool {
+	if br := p.existingBreaker(address); br != nil {
+		br.Reset()
+	}
+	return p.breakerPolicy != nil
+}
+
+// ForgetBackend drops what the pool keeps about a backend that was removed:
+// its circuit breaker and its metric series. Series the backend's remaining
+// sessions still report to are deleted when the last one ends.
+func (p *Pool) ForgetBackend(address string) {
+	p.sessionsMu.Lock()
+	if len(p.sessions[address]) > 0 {
+		if p.forgotten == nil {
+			p.forgotten = make(map[string]bool)
+		}
+		p.forgotten[address] = true
+	} else {
+		p.deleteSeries(address)
+	}
+	p.sessionsMu.Unlock()
+
+	if p.breakerPolicy == nil {
+		return
+	}
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+	delete(p.breakers, address)
+	breakerState.Delete(p.Name, address)
+	breakerTransitions.DeletePrefix(p.Name, address)
+}
+
+// deleteSeries removes the backend's session and dial metric series
+func (p *Pool) deleteSeries(address string) {
+	backendConnectionsActive.Delete(p.Name, address)
+	backendBytes.DeletePrefix(p.Name, address)
+	backendConnectionDuration.Delete(p.Name, address)
+	backendThrottled.DeletePrefix(p.Name, address)
+	backendDialErrors.DeletePrefix(p.Name, address)
+}
+
+// breaker returns the circuit breaker of a backend in the pool, creating
+// it on first use, or nil if the pool has no circuit breakers. Callers pass
+// addresses of the pool's current backends only, since breakers are kept
+// until ForgetBackend.
+func (p *Pool) breaker(address string) *CircuitBreaker {
+	if p.breakerPolicy == nil {
+		return nil
+	}
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+	br, ok := p.breakers[address]
+	if !ok {
+		br = NewCircuitBreaker(*p.breakerPolicy, func(state BreakerState) {
+			slog.Info("Circuit breaker changed state", "pool", p.Name, "backend", address, "state", string(state))
+			p.breakersMu.Lock()
+			defer p.breakersMu.Unlock()
+			// A forgotten breaker must not bring its series back
+			if p.breakers[address] != br {
+				return
+			}
+			breakerState.With(p.Name, address).Set(breakerStateValue(state))
+			breakerTransitions.With(p.Name, address, string(state)).Inc()
+		})
+		breakerState.With(p.Name, address).Set(breakerStateValue(BreakerClosed))
+		p.breakers[address] = br
+	}
+	return br
+}
+
+// existingBreaker returns the backend's circuit breaker without creating
+// one, or nil
+func (p *Pool) existingBreaker(address string) *CircuitBreaker {
+	if p.breakerPolicy == nil {
+		return nil
+	}
+	p.breakersMu.Lock()
+	defer p.breakersMu.Unlock()
+	return p.breakers[address]
+}
+
+// observe returns a function that reports a proxied connection's outcome to
+// the backend's circuit breaker when its session ends. A connection the
+// backend closes within the early failure window without sending anything
+// counts as a failure; one that outlives the window counts as a success.
+func (p *Pool) observe(backend *Backend) func(sessionResult) {
+	br := p.existingBreaker(backend.Address)
+	if br == nil {
+		return func(sessionResult) {}
+	}
+
+	trial := backend.trial
+	var once sync.Once
+	report := func(failed bool) {
+		once.Do(func() {
+			if failed {
+				br.failure(trial, time.Now())
+			} else {
+				br.success(trial)
+			}
+		})
+	}
+
+	window := p.breakerPolicy.EarlyFailureWindow
+	if window < 0 {
+		report(false)
+		return func(sessionResult) {}
+	}
+	timer := time.AfterFunc(window, func() { report(false) })
+	return func(result sessionResult) {
+		timer.Stop()
+		early := result.Duration < window && result.BytesOut == 0
+		report(early && (result.Reason == CloseBackendClosed || result.Reason == CloseError))
+	}
+}
+
+// Affinity returns the pool's affinity table, or nil
+func (p *Pool) Affinity() *AffinityTable {
+	return p.affinity
+}
+
+// Timeouts returns the pool's timeouts
+func (p *Pool) Timeouts() Timeouts {
+	return p.timeouts
+}
+
+// ActiveConnections returns how many connections the pool is proxying to
+// the backend at address
+func (p *Pool) ActiveConnections(address string) int64 {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	return int64(len(p.sessions[address]))
+}
+
+// CloseConnections forcibly closes every connection proxied to the backend
+// at address and returns how many were closed
+func (p *Pool) CloseConnections(address string) int {
+	p.sessionsMu.Lock()
+	sessions := make([]*session, 0, len(p.sessions[address]))
+	for s := range p.sessions[address] {
+		sessions = append(sessions, s)
+	}
+	p.sessionsMu.Unlock()
+
+	for _, s := range sessions {
+		s.abort(CloseDrained)
+	}
+	return len(sessions)
+}
+
+// trackSession attaches conn to s and records it as proxied to the backend
+// at address, unless the backend started draining or was removed after it
+// was selected. The check and the registration share sessionsMu, so a drain,
+// which marks the backend before counting its connections, either counts
+// the session or makes it go elsewhere.
+func (p *Pool) trackSession(address string, s *session, conn net.Conn) bool {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	if !p.accepting(address) {
+		return false
+	}
+	s.attach(address, conn)
+	delete(p.forgotten, address)
+	if p.sessions[address] == nil {
+		p.sessions[address] = make(map[*session]struct{})
+	}
+	p.sessions[address][s] = struct{}{}
+	return true
+}
+
+// accepting reports whether the backend at address is still in the pool
+// and not draining
+func (p *Pool) accepting(address string) bool {
+	for _, b := range p.source() {
+		if b.Address == address {
+			return !b.Draining
+		}
+	}
+	return false
+}
+
+// untrackSession forgets a finished connection, and the metric series of
+// its backend if that was removed and this was its last connection
+func (p *Pool) untrackSession(address string, s *session) {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	delete(p.sessions[address], s)
+	if len(p.sessions[address]) == 0 {
+		delete(p.sessions, address)
+		if p.forgotten[address] {
+			delete(p.forgotten, address)
+			p.deleteSeries(address)
+		}
+	}
+}
+
+// Backends returns the pool's current backends with their active
+// connection counts and slow start ramp filled in
+func (p *Pool) Backends() []Backend {
+	backends := p.source()
+
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	if len(p.sessions) == 0 && p.slowStart.Window <= 0 && p.breakerPolicy == nil {
+		return backends
+	}
+	now := time.Now()
+	counted := make([]Backend, len(backends))
+	for i, b := range backends {
+		b.ActiveConnections = int64(len(p.sessions[b.Address]))
+		b.ramp = p.slowStart.factor(b.HealthySince, now)
+		if br := p.breaker(b.Address); br != nil {
+			b.tripped = !br.allows(now)
+		}
+		counted[i] = b
+	}
+	return counted
+}
+
+// Select picks a backend for a new connection
+func (p *Pool) Select() *Backend {
+	return p.algorithm.SelectBackend(p.Backends())
+}
+
+// selectExcluding picks a backend other than the ones already tried and
+// returns how many available backends it chose from
+func (p *Pool) selectExcluding(tried map[string]bool) (*Backend, int) {
+	backends := p.Backends()
+	candidates := make([]Backend, 0, len(backends))
+	available := 0
+	for _, b := range backends {
+		if !tried[b.Address] {
+			candidates = append(candidates, b)
+			if b.available() {
+				available++
+			}
+		}
+	}
+	return p.algorithm.SelectBackend(candidates), available
+}
+
+// stickyBackend returns the available backend client is remembered as
+// belonging to, forgetting the client if that backend is gone or
+// unavailable
+func (p *Pool) stickyBackend(client netip.Addr) *Backend {
+	address, ok := p.affinity.Lookup(client, time.Now())
+	if !ok {
+		return nil
+	}
+	for _, b := range p.Backends() {
+		if b.Address == address && b.available() {
+			return &b
+		}
+	}
+	p.affinity.Delete(client)
+	return nil
+}
+
+// connect selects a backend for client and dials it, retrying on other
+// backends as allowed by the retry policy. It returns the number of
+// attempts made; backends skipped by their circuit breaker don't count as
+// attempts. Each selection and dial is traced as a child of span.
+// When s is not nil the backend connection is attached to it and the
+// session tracked before connect returns.
+func (p *Pool) connect(client net.Addr, s *session, span *tracing.Span) (*Backend, net.Conn, int, error) {
+	if p.budget != nil {
+		p.budget.deposit()
+	}
+
+	timeouts := p.timeouts
+	if p.retry.PerTryTimeout > 0 {
+		timeouts.Connect = p.retry.PerTryTimeout
+	}
+
+	ip := netutil.AddrIP(client)
+	sticky := p.affinity != nil && ip.IsValid()
+
+	tried := make(map[string]bool)
+	var lastErr error
+	for attempt := 1; ; {
+		selectSpan := span.Child("select_backend", tracing.KindInternal,
+			tracing.String("lb.algorithm", p.algName),
+			tracing.Int("lb.attempt", attempt),
+		)
+		var backend *Backend
+		if sticky && len(tried) == 0 {
+			backend = p.stickyBackend(ip)
+			selectSpan.SetAttributes(tracing.Bool("lb.sticky", backend != nil))
+		}
+		if backend == nil {
+			var candidates int
+			backend, candidates = p.selectExcluding(tried)
+			selectSpan.SetAttributes(tracing.Int("lb.candidates", candidates))
+		}
+		if backend == nil {
+			if lastErr == nil {
+				lastErr = ErrNoBackend
+			}
+			selectSpan.SetError(ErrNoBackend)
+			selectSpan.End()
+			return nil, nil, attempt - 1, lastErr
+		}
+		selectSpan.SetAttributes(tracing.String("lb.backend", backend.Address))
+
+		br := p.breaker(backend.Address)
+		if br != nil {
+			trial, ok := br.acquire(time.Now())
+			if !ok {
+				tried[backend.Address] = true
+				selectSpan.SetAttributes(tracing.Bool("lb.circuit_breaker_rejected", true))
+				selectSpan.End()
+				continue
+			}
+			backend.trial = trial
+		}
+		selectSpan.End()
+		if backend.Zone != "" {
+			zoneConnections.With(p.Name, backend.Zone).Inc()
+		}
+
+		dialSpan := span.Child("dial", tracing.KindClient,
+			tracing.String("server.address", backend.Address),
+			tracing.Int("lb.attempt", attempt),
+		)
+		conn, err := p.dial(backend, timeouts)
+		dialSpan.SetError(err)
+		dialSpan.End()
+		if err == nil && s != nil && !p.trackSession(backend.Address, s, conn) {
+			// The backend started draining while it was dialed. The
+			// connection was never used, so it says nothing about the
+			// backend's health.
+			conn.Close()
+			tried[backend.Address] = true
+			if br != nil {
+				br.release(backend.trial)
+			}
+			attempt++
+			continue
+		}
+		if err == nil {
+			if sticky {
+				p.affinity.Store(ip, backend.Address, time.Now())
+			}
+			return backend, conn, attempt, nil
+		}
+		tried[backend.Address] = true
+		if errors.Is(err, ErrPoolExhausted) {
+			// The backend wasn't tried, so it isn't held responsible
+			lastErr = err
+			if br != nil {
+				br.release(backend.trial)
+			}
+		} else {
+			lastErr = &DialError{Backend: backend.Address, Err: err}
+			if br != nil {
+				br.failure(backend.trial, time.Now())
+			}
+			backendDialErrors.With(p.Name, backend.Address, dialErrorType(err)).Inc()
+		}
+
+		if attempt > p.retry.Attempts || p.budget == nil || !p.budget.withdraw() {
+			return backend, nil, attempt, lastErr
+		}
+		backendDialRetries.With(p.Name).Inc()
+		slog.Info("Retrying connection after dial failed", "pool", p.Name, "backend", backend.Address, "error", err)
+		attempt++
+	}
+}
+
+// dial connects to the backend through its connection pool, waiting for a
+// free slot until the connect timeout when the pool is full, and dialing
+// directly if there is no pool. If no slot frees up in time it returns
+// ErrPoolExhausted.
+func (p *Pool) dial(backend *Backend, timeouts Timeouts) (net.Conn, error) {
+	if p.connPools == nil || backend.TLSConfig != nil {
+		return dialBackend(p.dialer, backend, timeouts)
+	}
+	cp := p.connPools(backend.Address)
+	if cp == nil {
+		return dialBackend(p.dialer, backend, timeouts)
+	}
+	connect := timeouts.Connect
+	if connect <= 0 {
+		connect = dialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connect)
+	defer cancel()
+	conn, err := cp.GetContext(ctx)
+	switch err {
+	case nil:
+		return &pooledConn{Conn: conn, pool: cp}, nil
+	case pool.ErrPoolClosed:
+		return dialBackend(p.dialer, backend, timeouts)
+	}
+	if errors.Is(err, pool.ErrPoolExhausted) {
+		return nil, fmt.Errorf("%w: %s", ErrPoolExhausted, backend.Address)
+	}
+	return nil, err
+}
+
+// pooledConn is a backend connection taken from a connection pool. A
+// proxied connection can't be reused by another client, so closing it
+// discards it and frees its slot in the pool.
+type pooledConn struct {
+	net.Conn
+	pool      *pool.ConnectionPool
+	closeOnce sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pool.Discard(c.Conn)
+	})
+	return nil
+}
+
+// CloseWrite half-closes the underlying connection if it supports it
+func (c *pooledConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
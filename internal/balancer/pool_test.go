@@ -0,0 +1,243 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/metrics"
+	"l4-load-balancer/pkg/dialer"
+	"l4-load-balancer/pkg/pool"
+)
+
+func TestPool_ConnectUsesConnectionPool(t *testing.T) {
+	live := liveAddress(t)
+	cp := pool.NewConnectionPool(live, 1)
+	defer cp.Close()
+
+	p := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{{Address: live, Healthy: true}})
+	p.SetConnectionPools(func(address string) *pool.ConnectionPool {
+		if address == live {
+			return cp
+		}
+		return nil
+	})
+
+	_, conn, _, err := p.connect(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*pooledConn); !ok {
+		t.Fatalf("connect returned %T, want a pooled connection", conn)
+	}
+	if got := cp.ActiveConnections(); got != 1 {
+		t.Errorf("connection pool has %d connections, want 1", got)
+	}
+
+	// A full pool is waited on until the connect timeout, which isn't held
+	// against the backend
+	p.SetTimeouts(Timeouts{Connect: 50 * time.Millisecond})
+	p.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 1})
+	dialErrors := backendDialErrors.With(p.Name, live, "timeout").Value()
+	if _, _, _, err := p.connect(nil, nil, nil); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Expected connect to a full pool to fail with ErrPoolExhausted, got %v", err)
+	}
+	if state := p.BreakerState(live); state != BreakerClosed {
+		t.Errorf("Expected the breaker to stay closed when the pool is exhausted, got %s", state)
+	}
+	if got := backendDialErrors.With(p.Name, live, "timeout").Value(); got != dialErrors {
+		t.Errorf("Expected no dial error to be counted, got %v more", got-dialErrors)
+	}
+
+	// and a waiting connect gets the slot when a connection is closed
+	p.SetTimeouts(Timeouts{Connect: time.Second})
+	time.AfterFunc(20*time.Millisecond, func() { conn.Close() })
+	_, waited, _, err := p.connect(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := waited.(*pooledConn); !ok {
+		t.Fatalf("connect returned %T, want a pooled connection", waited)
+	}
+	conn = waited
+
+	// Closing discards the connection rather than returning it
+	conn.Close()
+	conn.Close()
+	if got := cp.ActiveConnections(); got != 0 {
+		t.Errorf("connection pool has %d connections after close, want 0", got)
+	}
+	if got := cp.PoolSize(); got != 0 {
+		t.Errorf("connection pool has %d idle connections after close, want 0", got)
+	}
+}
+
+func TestPool_ConnectUsesDialer(t *testing.T) {
+	pipe := dialer.NewPipe()
+	defer pipe.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := pipe.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		accepted <- struct{}{}
+	}()
+
+	p := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{
+		{Address: "unix:///nonexistent.sock", Healthy: true},
+	})
+	p.SetDialer(pipe)
+
+	_, conn, _, err := p.connect(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("connection not made through the pool's dialer")
+	}
+}
+
+// hookDialer runs hook before each dial
+type hookDialer struct {
+	hook func(address string)
+}
+
+func (d hookDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.hook(address)
+	return dialer.Default.DialContext(ctx, network, address)
+}
+
+func TestPool_ConnectSkipsBackendDrainedDuringDial(t *testing.T) {
+	first, second := liveAddress(t), liveAddress(t)
+	var mu sync.Mutex
+	draining := ""
+	p := NewPool("test", NewRoundRobinAlgorithm(), func() []Backend {
+		mu.Lock()
+		defer mu.Unlock()
+		return []Backend{
+			{Address: first, Healthy: true, Draining: draining == first},
+			{Address: second, Healthy: true, Draining: draining == second},
+		}
+	})
+
+	// The backend picked first starts draining while it is being dialed
+	p.SetDialer(hookDialer{hook: func(address string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if draining == "" {
+			draining = address
+		}
+	}})
+
+	client, _ := tcpPair(t)
+	defer client.Close()
+	s := newSession(client, nil, Timeouts{})
+	backend, conn, _, err := p.connect(nil, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if backend.Address == draining {
+		t.Fatalf("Expected the draining backend %s to be skipped", draining)
+	}
+	if p.ActiveConnections(draining) != 0 || p.ActiveConnections(backend.Address) != 1 {
+		t.Errorf("Expected the session to be tracked on %s only", backend.Address)
+	}
+	if s.backend != conn {
+		t.Error("Expected the backend connection to be attached to the session")
+	}
+}
+
+func TestPool_DrainedDuringDialLeavesBreakerHalfOpen(t *testing.T) {
+	first, second := liveAddress(t), liveAddress(t)
+	var mu sync.Mutex
+	draining := ""
+	p := NewPool("test", NewRoundRobinAlgorithm(), func() []Backend {
+		mu.Lock()
+		defer mu.Unlock()
+		return []Backend{
+			{Address: first, Healthy: true, Draining: draining == first},
+			{Address: second, Healthy: true, Draining: draining == second},
+		}
+	})
+	p.SetDialer(hookDialer{hook: func(address string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if draining == "" {
+			draining = address
+		}
+	}})
+
+	// Both breakers are half-open, closing after a single successful trial
+	p.SetCircuitBreaker(BreakerPolicy{FailureThreshold: 1, OpenDuration: time.Millisecond, HalfOpenTrials: 1})
+	for _, address := range []string{first, second} {
+		p.breaker(address).failure(0, time.Now())
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	client, _ := tcpPair(t)
+	defer client.Close()
+	_, conn, _, err := p.connect(nil, newSession(client, nil, Timeouts{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The abandoned dial neither closed the breaker nor kept its trial slot
+	if state := p.BreakerState(draining); state != BreakerHalfOpen {
+		t.Errorf("Expected the drained backend's breaker to stay half-open, got %s", state)
+	}
+	if _, ok := p.breaker(draining).acquire(time.Now()); !ok {
+		t.Error("Expected the abandoned trial slot to be released")
+	}
+}
+
+func TestPool_ForgetBackendWaitsForSessions(t *testing.T) {
+	live := liveAddress(t)
+	var mu sync.Mutex
+	backends := []Backend{{Address: live, Healthy: true}}
+	p := NewPool("forget-sessions", NewRoundRobinAlgorithm(), func() []Backend {
+		mu.Lock()
+		defer mu.Unlock()
+		return backends
+	})
+
+	client, _ := tcpPair(t)
+	defer client.Close()
+	s := newSession(client, nil, Timeouts{})
+	_, conn, _, err := p.connect(nil, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	backendBytes.With(p.Name, live, "in").Add(1)
+	series := func() bool {
+		var out bytes.Buffer
+		metrics.DefaultRegistry.WriteText(&out)
+		return strings.Contains(out.String(), `pool="forget-sessions",backend="`+live+`"`)
+	}
+
+	// The backend's last session still reports when it ends
+	mu.Lock()
+	backends = nil
+	mu.Unlock()
+	p.ForgetBackend(live)
+	if !series() {
+		t.Fatal("Expected series to remain while a session is active")
+	}
+	p.untrackSession(live, s)
+	if series() {
+		t.Error("Expected series to be deleted after the last session ended")
+	}
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import "sort"
+
+// PriorityAlgorithm sends connections only to the most preferred priority
+// group that is healthy enough, failing over to backup groups as it
+// degrades. Within the chosen group the wrapped algorithm selects the
+// backend.
+type PriorityAlgorithm struct {
+	next              Algorithm
+	minHealthy        int
+	minHealthyPercent float64
+}
+
+// NewPriorityAlgorithm wraps next with priority group failover. A group is
+// used while at least minHealthy of its backends, and at least
+// minHealthyPercent of them, are available; minHealthy defaults to 1.
+func NewPriorityAlgorithm(next Algorithm, minHealthy int, minHealthyPercent float64) *PriorityAlgorithm {
+	if minHealthy < 1 {
+		minHealthy = 1
+	}
+	return &PriorityAlgorithm{
+		next:              next,
+		minHealthy:        minHealthy,
+		minHealthyPercent: minHealthyPercent,
+	}
+}
+
+// SelectBackend selects a backend from the active priority group
+func (pa *PriorityAlgorithm) SelectBackend(backends []Backend) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	if samePriority(backends) {
+		return pa.next.SelectBackend(backends)
+	}
+	return pa.next.SelectBackend(pa.activeGroup(backends))
+}
+
+// activeGroup returns the backends of the most preferred group that is
+// healthy enough. If no group is, the most preferred group with any
+// available backend is used so traffic keeps flowing.
+func (pa *PriorityAlgorithm) activeGroup(backends []Backend) []Backend {
+	groups := make(map[int][]Backend)
+	for _, b := range backends {
+		groups[b.Priority] = append(groups[b.Priority], b)
+	}
+	priorities := make([]int, 0, len(groups))
+	for priority := range groups {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	var fallback []Backend
+	for _, priority := range priorities {
+		group := groups[priority]
+		available := 0
+		for i := range group {
+			if group[i].available() {
+				available++
+			}
+		}
+		if available == 0 {
+			continue
+		}
+		if fallback == nil {
+			fallback = group
+		}
+		if available >= pa.minHealthy && float64(available)*100 >= pa.minHealthyPercent*float64(len(group)) {
+			return group
+		}
+	}
+	return fallback
+}
+
+// samePriority reports whether all backends are in one priority group
+func samePriority(backends []Backend) bool {
+	for i := 1; i < len(backends); i++ {
+		if backends[i].Priority != backends[0].Priority {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package balancer
+
+import "testing"
+
+func TestPriorityAlgorithm_FailsOverToBackups(t *testing.T) {
+	backends := []Backend{
+		{Address: "primary1:8081", Healthy: true},
+		{Address: "primary2:8082", Healthy: true},
+		{Address: "backup1:8083", Healthy: true, Priority: 1},
+		{Address: "backup2:8084", Healthy: true, Priority: 1},
+		{Address: "backup3:8085", Healthy: true, Priority: 2},
+	}
+	pa := NewPriorityAlgorithm(NewRoundRobinAlgorithm(), 2, 0)
+
+	selected := func() map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < 8; i++ {
+			if b := pa.SelectBackend(backends); b != nil {
+				counts[b.Address]++
+			}
+		}
+		return counts
+	}
+
+	if counts := selected(); counts["primary1:8081"] != 4 || counts["primary2:8082"] != 4 {
+		t.Errorf("Expected only the primary group, got %v", counts)
+	}
+
+	// One primary left is below min_healthy, so the first backup group
+	// takes over
+	backends[1].Healthy = false
+	if counts := selected(); counts["backup1:8083"] != 4 || counts["backup2:8084"] != 4 {
+		t.Errorf("Expected failover to priority 1, got %v", counts)
+	}
+
+	// No group has two available backends; the most preferred group with
+	// any available backend keeps serving
+	backends[2].Healthy = false
+	if counts := selected(); counts["primary1:8081"] != 8 {
+		t.Errorf("Expected the degraded primary to serve, got %v", counts)
+	}
+
+	backends[0].Draining = true
+	if counts := selected(); counts["backup2:8084"] != 8 {
+		t.Errorf("Expected the last backup to serve, got %v", counts)
+	}
+}
+
+func TestPriorityAlgorithm_MinHealthyPercent(t *testing.T) {
+	backends := []Backend{
+		{Address: "primary1:8081", Healthy: true},
+		{Address: "primary2:8082", Healthy: true},
+		{Address: "primary3:8083", Healthy: false},
+		{Address: "backup1:8084", Healthy: true, Priority: 1},
+	}
+
+	pa := NewPriorityAlgorithm(NewRoundRobinAlgorithm(), 1, 50)
+	if b := pa.SelectBackend(backends); b == nil || b.Priority != 0 {
+		t.Errorf("Expected 2/3 healthy primaries to serve, got %v", b)
+	}
+
+	pa = NewPriorityAlgorithm(NewRoundRobinAlgorithm(), 1, 75)
+	if b := pa.SelectBackend(backends); b == nil || b.Address != "backup1:8084" {
+		t.Errorf("Expected failover below 75%% healthy, got %v", b)
+	}
+
+	if b := pa.SelectBackend(nil); b != nil {
+		t.Errorf("Expected nil without backends, got %v", b)
+	}
+}
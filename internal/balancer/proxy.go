@@ -0,0 +1,329 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+	"l4-load-balancer/pkg/dialer"
+)
+
+// copyBufferSize is the buffer used per direction when proxying
+const copyBufferSize = 32 * 1024
+
+// CloseReason describes why a proxied connection ended or was refused
+type CloseReason string
+
+// Close reasons
+const (
+	CloseClientClosed  CloseReason = "client_closed"
+	CloseBackendClosed CloseReason = "backend_closed"
+	CloseIdleTimeout   CloseReason = "idle_timeout"
+	CloseMaxLifetime   CloseReason = "max_lifetime"
+	CloseDrained       CloseReason = "drained"
+	CloseError         CloseReason = "error"
+	CloseRejected      CloseReason = "rejected"
+	CloseDenied        CloseReason = "denied"
+	CloseRouteError    CloseReason = "route_error"
+	CloseNoBackend     CloseReason = "no_backend"
+	CloseDialError     CloseReason = "dial_error"
+	ClosePoolExhausted CloseReason = "pool_exhausted"
+)
+
+// Timeouts bounds the phases of a proxied connection. Zero values disable
+// the respective timeout.
+type Timeouts struct {
+	// Connect bounds dialing the backend, including the TLS handshake
+	Connect time.Duration
+	// Idle closes connections with no bytes flowing in either direction
+	Idle time.Duration
+	// MaxLifetime closes connections older than this
+	MaxLifetime time.Duration
+	// KeepAlive configures TCP keepalive on both the client and backend legs
+	KeepAlive net.KeepAliveConfig
+}
+
+// DefaultTimeouts returns the timeouts used by pools that don't set their own
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Connect:   dialTimeout,
+		KeepAlive: net.KeepAliveConfig{Enable: true},
+	}
+}
+
+// closeWriter is implemented by connections that support half-close
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// dialBackend connects to the backend with d, or dialer.Default if d is
+// nil, originating TLS when configured
+func dialBackend(d dialer.Dialer, backend *Backend, timeouts Timeouts) (net.Conn, error) {
+	ctx := context.Background()
+	if timeouts.Connect > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Connect)
+		defer cancel()
+	}
+	d = withKeepAlive(d, timeouts.KeepAlive)
+	if backend.TLSConfig != nil {
+		return dialer.DialTLS(ctx, d, backend.Address, backend.TLSConfig)
+	}
+	return dialer.Dial(ctx, d, backend.Address)
+}
+
+// withKeepAlive wraps d, or the default dialer if d is nil, so the TCP
+// connections it opens use the keepalive settings in config
+func withKeepAlive(d dialer.Dialer, config net.KeepAliveConfig) dialer.Dialer {
+	if d == nil {
+		d = dialer.Default
+	}
+	return keepAliveDialer{Dialer: d, config: config}
+}
+
+// keepAliveDialer applies keepalive settings to the TCP connections it opens
+type keepAliveDialer struct {
+	dialer.Dialer
+	config net.KeepAliveConfig
+}
+
+func (d keepAliveDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err == nil {
+		setKeepAlive(conn, d.config)
+	}
+	return conn, err
+}
+
+// setKeepAlive applies the keepalive settings to an accepted client connection
+func setKeepAlive(conn net.Conn, config net.KeepAliveConfig) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetKeepAliveConfig(config)
+	}
+}
+
+// sessionResult summarises a finished proxied connection
+type sessionResult struct {
+	Reason   CloseReason
+	BytesIn  int64 // client to backend
+	BytesOut int64 // backend to client
+	Duration time.Duration
+}
+
+// session proxies one client connection to one backend connection
+type session struct {
+	id       uint64
+	client   net.Conn
+	backend  net.Conn
+	timeouts Timeouts
+	start    time.Time
+
+	// address is the backend's address, set while the session is tracked
+	address string
+
+	lastActivity atomic.Int64
+	throttling   atomic.Int32
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	reason       atomic.Pointer[CloseReason]
+	closeOnce    sync.Once
+	done         chan struct{}
+
+	// mirror, when set, receives a copy of the client's bytes
+	mirror *mirrorStream
+
+	// bandwidth, when set, limits the byte rate in each direction;
+	// onThrottle is told how long each wait took and which limit caused it
+	bandwidth  *ratelimit.ConnThrottle
+	onThrottle func(dir ratelimit.Direction, scope ratelimit.BandwidthScope, wait time.Duration)
+}
+
+func newSession(client, backend net.Conn, timeouts Timeouts) *session {
+	s := &session{
+		id:       nextSessionID.Add(1),
+		client:   client,
+		backend:  backend,
+		timeouts: timeouts,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+	s.lastActivity.Store(s.start.UnixNano())
+	return s
+}
+
+// attach sets the session's backend connection once one has been dialed.
+// The session's duration and idle time count from here.
+func (s *session) attach(address string, backend net.Conn) {
+	s.address = address
+	s.backend = backend
+	s.start = time.Now()
+	s.lastActivity.Store(s.start.UnixNano())
+}
+
+// run copies data in both directions until both sides are done or a
+// timeout ends the session
+func (s *session) run() sessionResult {
+	if s.timeouts.MaxLifetime > 0 {
+		timer := time.AfterFunc(s.timeouts.MaxLifetime, func() {
+			s.abort(CloseMaxLifetime)
+		})
+		defer timer.Stop()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.pipe(s.backend, s.client, ratelimit.In, &s.bytesIn, CloseClientClosed)
+	}()
+	go func() {
+		defer wg.Done()
+		s.pipe(s.client, s.backend, ratelimit.Out, &s.bytesOut, CloseBackendClosed)
+	}()
+	wg.Wait()
+	if s.mirror != nil {
+		s.mirror.close()
+	}
+
+	reason := CloseError
+	if r := s.reason.Load(); r != nil {
+		reason = *r
+	}
+	return sessionResult{
+		Reason:   reason,
+		BytesIn:  s.bytesIn.Load(),
+		BytesOut: s.bytesOut.Load(),
+		Duration: time.Since(s.start),
+	}
+}
+
+// pipe copies src to dst, flowing in dir. On EOF it half-closes dst so
+// the peer sees EOF while the other direction keeps flowing.
+func (s *session) pipe(dst, src net.Conn, dir ratelimit.Direction, counter *atomic.Int64, eofReason CloseReason) {
+	buf := make([]byte, copyBufferSize)
+	if s.bandwidth != nil {
+		if chunk := s.bandwidth.Chunk(dir); chunk > 0 && chunk < len(buf) {
+			buf = buf[:chunk]
+		}
+	}
+	for {
+		if s.timeouts.Idle > 0 {
+			src.SetReadDeadline(time.Now().Add(s.timeouts.Idle))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if !s.throttle(dir, n) {
+				return
+			}
+			s.touch()
+			if s.timeouts.Idle > 0 {
+				dst.SetWriteDeadline(time.Now().Add(s.timeouts.Idle))
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				s.abort(s.classify(werr, CloseError))
+				return
+			}
+			counter.Add(int64(n))
+			if s.mirror != nil && src == s.client {
+				s.mirror.write(buf[:n])
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) && !s.idleExpired() {
+			// The other direction is still active
+			continue
+		}
+		if err == io.EOF {
+			s.setReason(eofReason)
+			if cw, ok := dst.(closeWriter); ok {
+				cw.CloseWrite()
+			} else {
+				s.abort(eofReason)
+			}
+			return
+		}
+		s.abort(s.classify(err, CloseError))
+		return
+	}
+}
+
+// classify maps a copy error to a close reason
+func (s *session) classify(err error, fallback CloseReason) CloseReason {
+	if errors.Is(err, os.ErrDeadlineExceeded) && s.idleExpired() {
+		return CloseIdleTimeout
+	}
+	return fallback
+}
+
+// throttle waits until the bandwidth limits allow n more bytes in dir. It
+// returns false if the session ended while waiting. A session waiting for
+// bandwidth is transferring, so it doesn't count as idle meanwhile.
+func (s *session) throttle(dir ratelimit.Direction, n int) bool {
+	if s.bandwidth == nil {
+		return true
+	}
+	wait, scope := s.bandwidth.Reserve(dir, n)
+	if wait <= 0 {
+		return true
+	}
+	if s.onThrottle != nil {
+		s.onThrottle(dir, scope, wait)
+	}
+	s.throttling.Add(1)
+	defer func() {
+		s.touch()
+		s.throttling.Add(-1)
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *session) idleExpired() bool {
+	if s.throttling.Load() > 0 {
+		return false
+	}
+	last := time.Unix(0, s.lastActivity.Load())
+	return s.timeouts.Idle > 0 && time.Since(last) >= s.timeouts.Idle
+}
+
+// setReason records the reason the session ended unless one is already set
+func (s *session) setReason(reason CloseReason) {
+	s.reason.CompareAndSwap(nil, &reason)
+}
+
+// abort ends the session for reason, closing both connections
+func (s *session) abort(reason CloseReason) {
+	s.setReason(reason)
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.client.Close()
+		s.backend.Close()
+	})
+}
+
+// reset closes the connection with a TCP RST instead of a graceful FIN
+func reset(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
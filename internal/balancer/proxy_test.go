@@ -0,0 +1,402 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+	"l4-load-balancer/internal/testutil"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialed, <-accepted
+}
+
+// proxiedPair proxies a client connection to a backend connection and
+// returns the outer ends plus a channel delivering the session result
+func proxiedPair(t *testing.T, timeouts Timeouts) (client, backend net.Conn, done <-chan sessionResult) {
+	t.Helper()
+	client, lbClient := tcpPair(t)
+	lbBackend, backend := tcpPair(t)
+
+	results := make(chan sessionResult, 1)
+	go func() {
+		results <- newSession(lbClient, lbBackend, timeouts).run()
+	}()
+	return client, backend, results
+}
+
+func waitResult(t *testing.T, done <-chan sessionResult) sessionResult {
+	t.Helper()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatal("Session did not finish")
+		return sessionResult{}
+	}
+}
+
+func TestSession_ClientClosed(t *testing.T) {
+	client, backend, done := proxiedPair(t, Timeouts{})
+	defer backend.Close()
+
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(backend, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Backend expected ping, got %q (%v)", buf, err)
+	}
+	backend.Write([]byte("pong!"))
+	client.(*net.TCPConn).CloseWrite()
+
+	// The backend still gets to answer after the client half-closes
+	reply, _ := io.ReadAll(io.LimitReader(client, 5))
+	if string(reply) != "pong!" {
+		t.Errorf("Client expected pong!, got %q", reply)
+	}
+	if _, err := backend.Read(buf); err != io.EOF {
+		t.Errorf("Backend expected EOF, got %v", err)
+	}
+	backend.Close()
+
+	result := waitResult(t, done)
+	if result.Reason != CloseClientClosed {
+		t.Errorf("Expected reason %s, got %s", CloseClientClosed, result.Reason)
+	}
+	if result.BytesIn != 4 || result.BytesOut != 5 {
+		t.Errorf("Expected 4 bytes in and 5 out, got %d and %d", result.BytesIn, result.BytesOut)
+	}
+	client.Close()
+}
+
+func TestSession_IdleTimeout(t *testing.T) {
+	client, backend, done := proxiedPair(t, Timeouts{Idle: 100 * time.Millisecond})
+	defer client.Close()
+	defer backend.Close()
+
+	// Traffic in one direction keeps the whole session alive
+	for i := 0; i < 5; i++ {
+		backend.Write([]byte("x"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("Session closed while data was flowing")
+	default:
+	}
+
+	start := time.Now()
+	result := waitResult(t, done)
+	if result.Reason != CloseIdleTimeout {
+		t.Errorf("Expected reason %s, got %s", CloseIdleTimeout, result.Reason)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Idle session took %v to close", elapsed)
+	}
+}
+
+func TestSession_ThrottledIsNotIdle(t *testing.T) {
+	const size = 2000
+	client, lbClient := tcpPair(t)
+	lbBackend, backend := tcpPair(t)
+	defer client.Close()
+
+	// Each 500 byte chunk waits 250ms for bandwidth, longer than the idle
+	// timeout, while the client sends nothing
+	s := newSession(lbClient, lbBackend, Timeouts{Idle: 100 * time.Millisecond})
+	s.bandwidth = ratelimit.NewThrottle(ratelimit.BandwidthConfig{
+		PerConnection: ratelimit.BandwidthLimits{Out: ratelimit.ByteRate{Rate: 2000, Burst: 500}},
+	}).Open(netip.Addr{}, "backend")
+	done := make(chan sessionResult, 1)
+	go func() { done <- s.run() }()
+
+	go func() {
+		backend.Write(make([]byte, size))
+		backend.Close()
+	}()
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := io.Copy(io.Discard, client)
+	if err != nil || n != size {
+		t.Fatalf("Expected %d bytes, got %d: %v", size, n, err)
+	}
+	client.Close()
+
+	result := waitResult(t, done)
+	if result.Reason == CloseIdleTimeout {
+		t.Errorf("Throttled session was closed as idle")
+	}
+}
+
+func TestSession_MaxLifetime(t *testing.T) {
+	client, backend, done := proxiedPair(t, Timeouts{MaxLifetime: 150 * time.Millisecond})
+	defer client.Close()
+	defer backend.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				client.Write([]byte("x"))
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}()
+	go io.Copy(io.Discard, backend)
+
+	result := waitResult(t, done)
+	if result.Reason != CloseMaxLifetime {
+		t.Errorf("Expected reason %s, got %s", CloseMaxLifetime, result.Reason)
+	}
+	if result.Duration < 150*time.Millisecond {
+		t.Errorf("Session ended early after %v", result.Duration)
+	}
+}
+
+func TestLoadBalancer_CloseReasons(t *testing.T) {
+	// Nothing listens on the backend address, so the dial fails
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	lb := NewLoadBalancer("", []Backend{{Address: deadAddr, Healthy: true}}, NewRoundRobinAlgorithm())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	io.Copy(io.Discard, conn)
+	conn.Close()
+
+	if n := lb.CloseReasons()[CloseDialError]; n != 1 {
+		t.Errorf("Expected 1 dial error, got %d", n)
+	}
+}
+
+func TestPool_CloseConnections(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	address := backendLn.Addr().String()
+	lb := NewLoadBalancer("", []Backend{{Address: address, Healthy: true}}, NewRoundRobinAlgorithm())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	pool := lb.DefaultPool()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.ActiveConnections(address) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection was never tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if backends := pool.Backends(); backends[0].ActiveConnections != 1 {
+		t.Errorf("Expected Backends to report 1 active connection, got %d", backends[0].ActiveConnections)
+	}
+
+	if n := pool.CloseConnections(address); n != 1 {
+		t.Errorf("Expected 1 connection closed, got %d", n)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the client connection to be closed")
+	}
+	for pool.ActiveConnections(address) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection was never untracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := lb.CloseReasons()[CloseDrained]; n != 1 {
+		t.Errorf("Expected 1 drained close, got %d", n)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func TestLoadBalancer_AccessLog(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	address := backendLn.Addr().String()
+	lb := NewLoadBalancer("", []Backend{{Address: address, Healthy: true}}, NewRoundRobinAlgorithm())
+	var out syncBuffer
+	lb.SetAccessLog(slog.New(slog.NewJSONHandler(&out, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(out.Bytes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("No access log record was written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var record struct {
+		Msg      string `json:"msg"`
+		Client   string `json:"client"`
+		Pool     string `json:"pool"`
+		Backend  string `json:"backend"`
+		BytesIn  int64  `json:"bytes_in"`
+		BytesOut int64  `json:"bytes_out"`
+		Reason   string `json:"reason"`
+		Retries  int    `json:"retries"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Invalid access log record %q: %v", out.Bytes(), err)
+	}
+	if record.Msg != "connection" || record.Client != conn.LocalAddr().String() {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if record.Pool != DefaultPoolName || record.Backend != address {
+		t.Errorf("Expected pool %s and backend %s, got %s and %s", DefaultPoolName, address, record.Pool, record.Backend)
+	}
+	if record.BytesIn != 5 || record.BytesOut != 5 {
+		t.Errorf("Expected 5 bytes each way, got %d in and %d out", record.BytesIn, record.BytesOut)
+	}
+	if record.Reason != string(CloseClientClosed) || record.Retries != 0 {
+		t.Errorf("Expected reason %s with no retries, got %s with %d", CloseClientClosed, record.Reason, record.Retries)
+	}
+}
+
+// denyAll is an access controller refusing every client
+type denyAll struct{}
+
+func (denyAll) Allowed(net.Addr) bool { return false }
+
+func TestLoadBalancer_AccessLogDenied(t *testing.T) {
+	lb := NewLoadBalancer("", []Backend{{Address: liveAddress(t), Healthy: true}}, NewRoundRobinAlgorithm())
+	lb.SetAccessController(denyAll{})
+	var out syncBuffer
+	lb.SetAccessLog(slog.New(slog.NewJSONHandler(&out, nil)))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testutil.WaitFor(t, "an access log record", func() bool { return len(out.Bytes()) > 0 })
+	var record struct {
+		Client string `json:"client"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Invalid access log record %q: %v", out.Bytes(), err)
+	}
+	if record.Client != conn.LocalAddr().String() || record.Reason != string(CloseDenied) {
+		t.Errorf("Expected a %s record for %s, got %+v", CloseDenied, conn.LocalAddr(), record)
+	}
+	if got := lb.CloseReasons()[CloseDenied]; got != 1 {
+		t.Errorf("Expected 1 denied connection, got %d", got)
+	}
+}
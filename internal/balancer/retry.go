@@ -0,0 +1,66 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+
+	"l4-load-balancer/internal/ratelimit"
+)
+
+// maxBudgetRetries caps how many retries the budget can save up
+const maxBudgetRetries = 100
+
+// RetryPolicy controls retrying a failed backend dial on another backend.
+// Retries only happen while dialing, before any client bytes have been
+// forwarded, so they are invisible to the client.
+type RetryPolicy struct {
+	// Attempts is the number of additional backends to try after the first
+	Attempts int
+	// PerTryTimeout bounds each dial attempt; zero uses the connect timeout
+	PerTryTimeout time.Duration
+	// BudgetPercent limits retries to this percentage of connections
+	BudgetPercent float64
+	// MinRetriesPerSecond is always allowed regardless of the budget so
+	// low-traffic pools can still retry
+	MinRetriesPerSecond float64
+}
+
+// retryBudget limits retries to a fraction of traffic to avoid retry storms
+// when many backends fail at once. The balance is kept in percent so each
+// connection credits BudgetPercent and each retry costs 100.
+type retryBudget struct {
+	mu      sync.Mutex
+	percent float64
+	balance float64
+	reserve *ratelimit.TokenBucket
+}
+
+func newRetryBudget(policy RetryPolicy) *retryBudget {
+	b := &retryBudget{percent: policy.BudgetPercent}
+	if policy.MinRetriesPerSecond > 0 {
+		burst := int(policy.MinRetriesPerSecond)
+		b.reserve = ratelimit.NewTokenBucket(policy.MinRetriesPerSecond, burst, time.Now())
+	}
+	return b
+}
+
+// deposit credits the budget for a new connection
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balance += b.percent
+	if b.balance > maxBudgetRetries*100 {
+		b.balance = maxBudgetRetries * 100
+	}
+}
+
+// withdraw reports whether a retry may be made and charges it to the budget
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance >= 100 {
+		b.balance -= 100
+		return true
+	}
+	return b.reserve != nil && b.reserve.Take(1, time.Now())
+}
@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// deadAddress returns an address nothing is listening on
+func deadAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestPool_ConnectRetriesOtherBackend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dead := deadAddress(t)
+	pool := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{
+		{Address: ln.Addr().String(), Healthy: true},
+		{Address: dead, Healthy: true},
+	})
+	pool.SetRetryPolicy(RetryPolicy{Attempts: 2, PerTryTimeout: time.Second, MinRetriesPerSecond: 100})
+
+	// Round robin starts at the second backend, which is down
+	backend, conn, attempts, err := pool.connect(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected retry to succeed, got %v", err)
+	}
+	conn.Close()
+	if backend.Address != ln.Addr().String() || attempts != 2 {
+		t.Errorf("Expected live backend on attempt 2, got %s on attempt %d", backend.Address, attempts)
+	}
+}
+
+func TestPool_ConnectWithoutRetries(t *testing.T) {
+	pool := NewStaticPool("test", NewRoundRobinAlgorithm(), []Backend{
+		{Address: deadAddress(t), Healthy: true},
+		{Address: deadAddress(t), Healthy: true},
+	})
+
+	_, _, attempts, err := pool.connect(nil, nil, nil)
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || attempts != 1 {
+		t.Errorf("Expected a single failed attempt, got %d attempts and %v", attempts, err)
+	}
+
+	// Every backend is tried at most once
+	pool.SetRetryPolicy(RetryPolicy{Attempts: 5, MinRetriesPerSecond: 100})
+	_, _, attempts, err = pool.connect(nil, nil, nil)
+	if !errors.As(err, &dialErr) || attempts != 2 {
+		t.Errorf("Expected 2 failed attempts, got %d and %v", attempts, err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(RetryPolicy{BudgetPercent: 20})
+
+	if budget.withdraw() {
+		t.Fatal("Expected empty budget to refuse retries")
+	}
+
+	for i := 0; i < 10; i++ {
+		budget.deposit()
+	}
+	retries := 0
+	for budget.withdraw() {
+		retries++
+	}
+	if retries != 2 {
+		t.Errorf("Expected 20%% of 10 connections to allow 2 retries, got %d", retries)
+	}
+
+	reserve := newRetryBudget(RetryPolicy{MinRetriesPerSecond: 2})
+	if !reserve.withdraw() || !reserve.withdraw() || reserve.withdraw() {
+		t.Error("Expected the minimum reserve to allow exactly 2 retries")
+	}
+}
@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+// SlowStart ramps up the share of traffic a backend receives after it
+// becomes healthy, so backends that need to warm up aren't overloaded
+type SlowStart struct {
+	// Window is how long the ramp lasts; zero disables slow start
+	Window time.Duration
+
+	// MinWeightPercent is the share of its full weight a backend starts
+	// the ramp with
+	MinWeightPercent float64
+
+	// Aggression shapes the ramp: 1 is linear, larger values ramp up
+	// faster at the start and smaller values slower
+	Aggression float64
+}
+
+// DefaultSlowStartMinWeightPercent is the starting share of a backend's
+// weight when none is configured
+const DefaultSlowStartMinWeightPercent = 10
+
+// factor returns the fraction of its weight a backend healthy since
+// healthySince should get at now, in (0, 1]
+func (s SlowStart) factor(healthySince, now time.Time) float64 {
+	if s.Window <= 0 || healthySince.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(healthySince)
+	if elapsed >= s.Window {
+		return 1
+	}
+
+	floor := s.MinWeightPercent / 100
+	if floor <= 0 {
+		floor = DefaultSlowStartMinWeightPercent / 100.0
+	}
+	aggression := s.Aggression
+	if aggression <= 0 {
+		aggression = 1
+	}
+
+	progress := math.Max(float64(elapsed), 0) / float64(s.Window)
+	return math.Min(math.Max(math.Pow(progress, 1/aggression), floor), 1)
+}
@@ -0,0 +1,54 @@
+package balancer
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStart_Factor(t *testing.T) {
+	now := time.Now()
+	linear := SlowStart{Window: 100 * time.Second, MinWeightPercent: 10}
+	aggressive := SlowStart{Window: 100 * time.Second, MinWeightPercent: 10, Aggression: 2}
+
+	tests := []struct {
+		name      string
+		slowStart SlowStart
+		elapsed   time.Duration
+		expected  float64
+	}{
+		{"disabled", SlowStart{}, 0, 1},
+		{"starts at the floor", linear, 0, 0.1},
+		{"linear midpoint", linear, 50 * time.Second, 0.5},
+		{"window over", linear, 150 * time.Second, 1},
+		{"aggressive ramps faster", aggressive, 25 * time.Second, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.slowStart.factor(now.Add(-tt.elapsed), now)
+			if math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if got := linear.factor(time.Time{}, now); got != 1 {
+		t.Errorf("Expected full weight without a healthy time, got %v", got)
+	}
+}
+
+func TestPool_SlowStartRampsBackends(t *testing.T) {
+	pool := NewStaticPool("test", NewWeightedRoundRobinAlgorithm(), []Backend{
+		{Address: "server1:8081", Healthy: true, Weight: 2},
+		{Address: "server2:8082", Healthy: true, Weight: 2, HealthySince: time.Now()},
+	})
+	pool.SetSlowStart(SlowStart{Window: time.Hour, MinWeightPercent: 25})
+
+	backends := pool.Backends()
+	if w := backends[0].EffectiveWeight(); w != 2 {
+		t.Errorf("Expected full weight for established backend, got %v", w)
+	}
+	if w := backends[1].EffectiveWeight(); math.Abs(w-0.5) > 1e-3 {
+		t.Errorf("Expected ramped weight 0.5 for new backend, got %v", w)
+	}
+}
@@ -0,0 +1,198 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"l4-load-balancer/pkg/netutil"
+)
+
+// SplitVariant is one destination pool of a traffic split
+type SplitVariant struct {
+	Pool   string `json:"pool"`
+	Weight int    `json:"weight"`
+}
+
+// SplitVariantStats counts the connections a variant received
+type SplitVariantStats struct {
+	Pool        string `json:"pool"`
+	Weight      int    `json:"weight"`
+	Connections uint64 `json:"connections"`
+	Errors      uint64 `json:"errors"`
+}
+
+// SplitStats describes a split in API responses
+type SplitStats struct {
+	Name       string              `json:"name"`
+	Pool       string              `json:"pool"`
+	Consistent bool                `json:"consistent"`
+	Variants   []SplitVariantStats `json:"variants"`
+}
+
+// splitCounters holds a variant's connection and error counts
+type splitCounters struct {
+	connections atomic.Uint64
+	errors      atomic.Uint64
+}
+
+// Split divides the connections routed to a pool between several pools by
+// weight, for example to send 5% of traffic to a canary. With consistent
+// set, a client IP is always assigned the same variant while the weights
+// stay the same, and ramping weights up only moves the clients needed.
+type Split struct {
+	Name       string
+	Pool       string
+	Consistent bool
+
+	mu       sync.RWMutex
+	variants []SplitVariant
+	counters map[string]*splitCounters
+}
+
+// NewSplit creates a split of the connections routed to pool
+func NewSplit(name, pool string, variants []SplitVariant, consistent bool) (*Split, error) {
+	s := &Split{
+		Name:       name,
+		Pool:       pool,
+		Consistent: consistent,
+		variants:   append([]SplitVariant(nil), variants...),
+		counters:   make(map[string]*splitCounters),
+	}
+	for _, v := range variants {
+		s.counters[v.Pool] = &splitCounters{}
+	}
+	if len(s.counters) != len(variants) {
+		return nil, fmt.Errorf("split %s lists a pool more than once", name)
+	}
+	if err := s.SetWeights(weightsOf(variants)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetWeights changes the weights of the split's variants
+func (s *Split) SetWeights(weights map[string]int) error {
+	if err := s.CheckWeights(weights); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.variants {
+		s.variants[i].Weight = weights[s.variants[i].Pool]
+	}
+	return nil
+}
+
+// CheckWeights reports whether weights are valid for the split: every
+// variant must be given a weight and at least one must be positive
+func (s *Split) CheckWeights(weights map[string]int) error {
+	total := 0
+	for pool, weight := range weights {
+		if _, ok := s.counters[pool]; !ok {
+			return fmt.Errorf("split %s has no variant for pool %s", s.Name, pool)
+		}
+		if weight < 0 {
+			return fmt.Errorf("negative weight %d for pool %s", weight, pool)
+		}
+		total += weight
+	}
+	if len(weights) != len(s.counters) {
+		return fmt.Errorf("split %s needs a weight for each of its %d pools", s.Name, len(s.counters))
+	}
+	if total == 0 {
+		return fmt.Errorf("split %s needs at least one positive weight", s.Name)
+	}
+	return nil
+}
+
+// Variants returns the split's variants and their current weights
+func (s *Split) Variants() []SplitVariant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]SplitVariant(nil), s.variants...)
+}
+
+// Choose returns the pool a new connection from client is sent to
+func (s *Split) Choose(client net.Addr) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := 0
+	for _, v := range s.variants {
+		total += v.Weight
+	}
+
+	var point float64
+	ip := netutil.AddrIP(client)
+	if s.Consistent && ip.IsValid() {
+		h := fnv.New64a()
+		h.Write(ip.AsSlice())
+		point = float64(mix64(h.Sum64())) / (math.MaxUint64 + 1.0) * float64(total)
+	} else {
+		point = rand.Float64() * float64(total)
+	}
+
+	for _, v := range s.variants {
+		if point < float64(v.Weight) {
+			return v.Pool
+		}
+		point -= float64(v.Weight)
+	}
+	return s.variants[len(s.variants)-1].Pool
+}
+
+// record counts a connection sent to pool and whether it failed to reach
+// a backend
+func (s *Split) record(pool string, failed bool) {
+	c, ok := s.counters[pool]
+	if !ok {
+		return
+	}
+	c.connections.Add(1)
+	splitConnections.With(s.Name, pool).Inc()
+	if failed {
+		c.errors.Add(1)
+		splitErrors.With(s.Name, pool).Inc()
+	}
+}
+
+// Stats returns the split's weights and per-variant counts
+func (s *Split) Stats() SplitStats {
+	stats := SplitStats{Name: s.Name, Pool: s.Pool, Consistent: s.Consistent}
+	for _, v := range s.Variants() {
+		c := s.counters[v.Pool]
+		stats.Variants = append(stats.Variants, SplitVariantStats{
+			Pool:        v.Pool,
+			Weight:      v.Weight,
+			Connections: c.connections.Load(),
+			Errors:      c.errors.Load(),
+		})
+	}
+	return stats
+}
+
+// mix64 spreads the bits of an FNV hash, whose high bits barely change
+// between short inputs such as IP addresses
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// weightsOf returns the variants' weights by pool
+func weightsOf(variants []SplitVariant) map[string]int {
+	weights := make(map[string]int, len(variants))
+	for _, v := range variants {
+		weights[v.Pool] = v.Weight
+	}
+	return weights
+}
@@ -0,0 +1,111 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestSplit_Weights(t *testing.T) {
+	split, err := NewSplit("canary", "default", []SplitVariant{
+		{Pool: "default", Weight: 95},
+		{Pool: "canary", Weight: 5},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[split.Choose(nil)]++
+	}
+	if c := counts["canary"]; c < 350 || c > 650 {
+		t.Errorf("Expected about 5%% canary traffic, got %v", counts)
+	}
+
+	if err := split.SetWeights(map[string]int{"default": 0, "canary": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if pool := split.Choose(nil); pool != "canary" {
+		t.Errorf("Expected all traffic on canary, got %s", pool)
+	}
+
+	invalid := []map[string]int{
+		{"default": 1},
+		{"default": 0, "canary": 0},
+		{"default": 1, "canary": -1},
+		{"default": 1, "other": 1},
+	}
+	for _, weights := range invalid {
+		if err := split.SetWeights(weights); err == nil {
+			t.Errorf("Expected %v to be rejected", weights)
+		}
+	}
+
+	if _, err := NewSplit("dup", "default", []SplitVariant{{Pool: "a", Weight: 1}, {Pool: "a", Weight: 1}}, false); err == nil {
+		t.Error("Expected duplicate variants to be rejected")
+	}
+}
+
+func TestSplit_ConsistentAssignment(t *testing.T) {
+	split, err := NewSplit("canary", "default", []SplitVariant{
+		{Pool: "default", Weight: 95},
+		{Pool: "canary", Weight: 5},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]net.Addr, 1000)
+	before := make([]string, len(clients))
+	for i := range clients {
+		clients[i] = &net.TCPAddr{IP: net.ParseIP(fmt.Sprintf("10.0.%d.%d", i/256, i%256)), Port: 1000 + i}
+		before[i] = split.Choose(clients[i])
+		other := &net.TCPAddr{IP: clients[i].(*net.TCPAddr).IP, Port: 50000}
+		if split.Choose(other) != before[i] {
+			t.Fatalf("Client %s changed variant between connections", clients[i])
+		}
+	}
+
+	// Ramping the canary up keeps existing canary clients on the canary
+	split.SetWeights(map[string]int{"default": 80, "canary": 20})
+	moved := 0
+	for i, client := range clients {
+		after := split.Choose(client)
+		if before[i] == "canary" && after != "canary" {
+			t.Fatalf("Canary client %s moved back to %s", client, after)
+		}
+		if before[i] != after {
+			moved++
+		}
+	}
+	if moved < 80 || moved > 220 {
+		t.Errorf("Expected about 15%% of clients to move, got %d", moved)
+	}
+}
+
+func TestLoadBalancer_SplitCountsErrors(t *testing.T) {
+	lb := NewLoadBalancer("", []Backend{{Address: liveAddress(t), Healthy: true}}, NewRoundRobinAlgorithm())
+	lb.AddPool(NewStaticPool("canary", NewRoundRobinAlgorithm(), []Backend{{Address: deadAddress(t), Healthy: true}}))
+	split, err := NewSplit("canary", DefaultPoolName, []SplitVariant{
+		{Pool: DefaultPoolName, Weight: 0},
+		{Pool: "canary", Weight: 1},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.AddSplit(split)
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	lb.handleConnection(server, nil)
+
+	stats := split.Stats()
+	canary := stats.Variants[1]
+	if canary.Pool != "canary" || canary.Connections != 1 || canary.Errors != 1 {
+		t.Errorf("Expected one failed canary connection, got %+v", stats.Variants)
+	}
+	if stats.Variants[0].Connections != 0 {
+		t.Errorf("Expected no default connections, got %+v", stats.Variants[0])
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"l4-load-balancer/internal/tracing"
+)
+
+// attr returns the value of the span attribute named key
+func attr(span tracetest.SpanStub, key string) any {
+	for _, a := range span.Attributes {
+		if string(a.Key) == key {
+			return a.Value.AsInterface()
+		}
+	}
+	return nil
+}
+
+func TestLoadBalancer_Tracing(t *testing.T) {
+	backendLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	address := backendLn.Addr().String()
+	lb := NewLoadBalancer("", []Backend{{Address: address, Healthy: true}}, NewRoundRobinAlgorithm())
+	recorder := tracetest.NewInMemoryExporter()
+	tracer := tracing.NewTracer(recorder, tracing.Options{})
+	lb.SetTracer(tracer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for lb.CloseReasons()[CloseClientClosed] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer tracer.Shutdown(context.Background())
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range recorder.GetSpans() {
+		spans[span.Name] = span
+	}
+	root, ok := spans["connection"]
+	if !ok {
+		t.Fatalf("No connection span in %+v", recorder.GetSpans())
+	}
+	for _, name := range []string{"route", "select_backend", "dial"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("No %s span", name)
+			continue
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() || span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("Expected %s to be a child of the connection span", name)
+		}
+	}
+
+	if v := attr(spans["select_backend"], "lb.algorithm"); v != "round_robin" {
+		t.Errorf("Expected algorithm round_robin, got %v", v)
+	}
+	if v := attr(spans["select_backend"], "lb.candidates"); v != int64(1) {
+		t.Errorf("Expected 1 candidate, got %v", v)
+	}
+	if v := attr(spans["dial"], "server.address"); v != address {
+		t.Errorf("Expected dial to %s, got %v", address, v)
+	}
+	if attr(root, "lb.bytes_in") != int64(5) || attr(root, "lb.bytes_out") != int64(5) {
+		t.Errorf("Expected 5 bytes each way, got %v", root.Attributes)
+	}
+	if v := attr(root, "lb.close_reason"); v != string(CloseClientClosed) || root.Status.Code != codes.Unset {
+		t.Errorf("Expected close reason %s without error, got %v and status %v", CloseClientClosed, v, root.Status.Code)
+	}
+}
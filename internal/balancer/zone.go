@@ -0,0 +1,94 @@
+package balancer
+
+import "math/rand/v2"
+
+// DefaultZoneMinLocalPercent is the share of local capacity that must be
+// available before traffic starts spilling to other zones
+const DefaultZoneMinLocalPercent = 70
+
+// ZoneAwareAlgorithm prefers backends in the load balancer's own zone.
+// While the available local capacity is below the threshold, the shortfall
+// is sent to other zones in proportion; the wrapped algorithm selects the
+// backend within the chosen set.
+type ZoneAwareAlgorithm struct {
+	next            Algorithm
+	localZone       string
+	minLocalPercent float64
+}
+
+// NewZoneAwareAlgorithm wraps next with a preference for backends in
+// localZone. Traffic stays local while at least minLocalPercent of the
+// local backends' weight is available.
+func NewZoneAwareAlgorithm(next Algorithm, localZone string, minLocalPercent float64) *ZoneAwareAlgorithm {
+	if minLocalPercent <= 0 || minLocalPercent > 100 {
+		minLocalPercent = DefaultZoneMinLocalPercent
+	}
+	return &ZoneAwareAlgorithm{
+		next:            next,
+		localZone:       localZone,
+		minLocalPercent: minLocalPercent,
+	}
+}
+
+// SelectBackend selects a backend, preferring the local zone. While the
+// available share of the local zone's weight is below the threshold, the
+// traffic the zone is short of, 1 - available/threshold, spills to the
+// remote zones, each receiving a part in proportion to its available weight.
+func (za *ZoneAwareAlgorithm) SelectBackend(backends []Backend) *Backend {
+	if za.localZone == "" {
+		return za.next.SelectBackend(backends)
+	}
+
+	local := make([]Backend, 0, len(backends))
+	remote := make(map[string][]Backend)
+	remoteCapacity := make(map[string]float64)
+	var zones []string
+	var total, available, remoteAvailable float64
+	for _, b := range backends {
+		if b.Zone != za.localZone {
+			if _, ok := remote[b.Zone]; !ok {
+				zones = append(zones, b.Zone)
+			}
+			remote[b.Zone] = append(remote[b.Zone], b)
+			if b.available() {
+				remoteCapacity[b.Zone] += b.EffectiveWeight()
+				remoteAvailable += b.EffectiveWeight()
+			}
+			continue
+		}
+		local = append(local, b)
+		total += float64(b.weight())
+		if b.available() {
+			available += b.EffectiveWeight()
+		}
+	}
+
+	switch {
+	case len(local) == 0:
+		return za.next.SelectBackend(backends)
+	case remoteAvailable == 0:
+		return za.next.SelectBackend(local)
+	}
+
+	localShare := available * 100 / total / za.minLocalPercent
+	if available > 0 && (localShare >= 1 || rand.Float64() < localShare) {
+		return za.next.SelectBackend(local)
+	}
+
+	// Pick a remote zone by its share of the remote capacity; rounding can
+	// leave pick just past the end, which goes to the last zone with any
+	pick := rand.Float64() * remoteAvailable
+	chosen := ""
+	for _, zone := range zones {
+		capacity := remoteCapacity[zone]
+		if capacity == 0 {
+			continue
+		}
+		chosen = zone
+		if pick < capacity {
+			break
+		}
+		pick -= capacity
+	}
+	return za.next.SelectBackend(remote[chosen])
+}
@@ -0,0 +1,93 @@
+package balancer
+
+import "testing"
+
+func zoneCounts(za *ZoneAwareAlgorithm, backends []Backend, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if b := za.SelectBackend(backends); b != nil {
+			counts[b.Zone]++
+		}
+	}
+	return counts
+}
+
+func TestZoneAwareAlgorithm_PrefersLocalZone(t *testing.T) {
+	backends := []Backend{
+		{Address: "a1:8081", Healthy: true, Zone: "zone-a"},
+		{Address: "a2:8082", Healthy: true, Zone: "zone-a"},
+		{Address: "a3:8083", Healthy: true, Zone: "zone-a"},
+		{Address: "a4:8084", Healthy: true, Zone: "zone-a"},
+		{Address: "b1:8085", Healthy: true, Zone: "zone-b"},
+	}
+	za := NewZoneAwareAlgorithm(NewRoundRobinAlgorithm(), "zone-a", 80)
+
+	if counts := zoneCounts(za, backends, 100); counts["zone-a"] != 100 {
+		t.Errorf("Expected all traffic to stay local, got %v", counts)
+	}
+
+	// 3 of 4 local backends is 75% of capacity, below the 80% threshold,
+	// so about 1 - 75/80 of traffic spills
+	backends[0].Healthy = false
+	counts := zoneCounts(za, backends, 4000)
+	if remote := counts["zone-b"]; remote < 100 || remote > 400 {
+		t.Errorf("Expected roughly 6%% of traffic to spill, got %v", counts)
+	}
+
+	// Without any local capacity everything goes remote
+	for i := 0; i < 4; i++ {
+		backends[i].Healthy = false
+	}
+	if counts := zoneCounts(za, backends, 10); counts["zone-b"] != 10 {
+		t.Errorf("Expected all traffic to go remote, got %v", counts)
+	}
+}
+
+func TestZoneAwareAlgorithm_NoRemoteCapacity(t *testing.T) {
+	backends := []Backend{
+		{Address: "a1:8081", Healthy: true, Zone: "zone-a"},
+		{Address: "a2:8082", Healthy: false, Zone: "zone-a"},
+		{Address: "b1:8083", Healthy: false, Zone: "zone-b"},
+	}
+	za := NewZoneAwareAlgorithm(NewRoundRobinAlgorithm(), "zone-a", 0)
+	if counts := zoneCounts(za, backends, 20); counts["zone-a"] != 20 {
+		t.Errorf("Expected degraded local zone to serve when remote is down, got %v", counts)
+	}
+
+	// Backends without a local zone are passed through unchanged
+	za = NewZoneAwareAlgorithm(NewRoundRobinAlgorithm(), "zone-c", 0)
+	if counts := zoneCounts(za, backends, 20); counts["zone-a"] != 20 {
+		t.Errorf("Expected pass-through without local backends, got %v", counts)
+	}
+}
+
+func TestZoneAwareAlgorithm_SpillsByRemoteCapacity(t *testing.T) {
+	backends := []Backend{
+		{Address: "a1:8081", Healthy: false, Zone: "zone-a"},
+		{Address: "b1:8082", Healthy: true, Zone: "zone-b", Weight: 3},
+		{Address: "c1:8083", Healthy: true, Zone: "zone-c"},
+		{Address: "c2:8084", Healthy: false, Zone: "zone-c", Weight: 5},
+	}
+	za := NewZoneAwareAlgorithm(NewRoundRobinAlgorithm(), "zone-a", 0)
+
+	// zone-b has 3 of the 4 available remote weight, whatever the number
+	// of backends in each zone
+	counts := zoneCounts(za, backends, 4000)
+	if b := counts["zone-b"]; b < 2800 || b > 3200 || counts["zone-a"] != 0 {
+		t.Errorf("Expected about 75%% of traffic in zone-b, got %v", counts)
+	}
+}
+
+func TestPool_CountsZoneSelections(t *testing.T) {
+	dead := deadAddress(t)
+	pool := NewStaticPool("zones", NewRoundRobinAlgorithm(), []Backend{{Address: dead, Healthy: true, Zone: "zone-a"}})
+
+	// A selection counts even when its dial fails
+	before := zoneConnections.With("zones", "zone-a").Value()
+	if _, _, _, err := pool.connect(nil, nil, nil); err == nil {
+		t.Fatal("Expected the dead backend to fail")
+	}
+	if v := zoneConnections.With("zones", "zone-a").Value() - before; v != 1 {
+		t.Errorf("Expected 1 selection in zone-a, got %v", v)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -12,18 +13,300 @@ type Config struct {
 	LoadBalancer LoadBalancerConfig `yaml:"loadbalancer"`
 	Backends     []BackendConfig    `yaml:"backends"`
 	HealthCheck  HealthCheckConfig  `yaml:"healthcheck"`
+	Pools        []PoolConfig       `yaml:"pools,omitempty"`
+	Metrics      MetricsConfig      `yaml:"metrics,omitempty"`
+	Admin        AdminConfig        `yaml:"admin,omitempty"`
+	Logging      LoggingConfig      `yaml:"logging,omitempty"`
+	Tracing      *TracingConfig     `yaml:"tracing,omitempty"`
+}
+
+// TracingConfig records a trace span for every proxied connection and sends
+// it to an Exporter: "otlp" posts to the collector at Endpoint (OTLP over
+// HTTP, e.g. http://localhost:4318) with Headers, "stdout" and
+// "file" write one JSON span per line, the latter to File. SamplePercent
+// defaults to 100 and ServiceName to "l4-load-balancer".
+type TracingConfig struct {
+	Exporter      string            `yaml:"exporter"`
+	Endpoint      string            `yaml:"endpoint,omitempty"`
+	Headers       map[string]string `yaml:"headers,omitempty"`
+	File          string            `yaml:"file,omitempty"`
+	ServiceName   string            `yaml:"service_name,omitempty"`
+	SamplePercent float64           `yaml:"sample_percent,omitempty"`
+}
+
+// LoggingConfig sets the Level ("debug", "info", "warn" or "error") and
+// Format ("text" or "json") of the log written to stderr, and enables the
+// access log
+type LoggingConfig struct {
+	Level  string           `yaml:"level,omitempty"`
+	Format string           `yaml:"format,omitempty"`
+	Access *AccessLogConfig `yaml:"access,omitempty"`
+}
+
+// AccessLogConfig writes a record for every connection to File, or to
+// stderr with the rest of the log when File is empty. The file is rotated
+// when it reaches MaxSizeMB megabytes (default 100), keeping MaxBackups old
+// files (default 5), and reopened on SIGUSR1. Format defaults to the log's.
+type AccessLogConfig struct {
+	File       string `yaml:"file,omitempty"`
+	Format     string `yaml:"format,omitempty"`
+	MaxSizeMB  int    `yaml:"max_size_mb,omitempty"`
+	MaxBackups int    `yaml:"max_backups,omitempty"`
+}
+
+// AdminConfig enables the admin HTTP API when ListenAddress is set. With
+// Persist, runtime changes to backends are written back to the config file.
+type AdminConfig struct {
+	ListenAddress string `yaml:"listen_address,omitempty"`
+	Persist       bool   `yaml:"persist,omitempty"`
+}
+
+// MetricsConfig exposes Prometheus metrics over HTTP when ListenAddress is set
+type MetricsConfig struct {
+	ListenAddress string `yaml:"listen_address,omitempty"`
+	Path          string `yaml:"path,omitempty"`
 }
 
 // LoadBalancerConfig contains load balancer specific settings
 type LoadBalancerConfig struct {
-	ListenAddress string `yaml:"listen_address"`
-	Algorithm     string `yaml:"algorithm"`
+	ListenAddress string          `yaml:"listen_address"`
+	Zone          string          `yaml:"zone,omitempty"`
+	Algorithm     string          `yaml:"algorithm"`
+	Sniffing      *SniffingConfig `yaml:"sniffing,omitempty"`
+	ACL           *ACLConfig      `yaml:"acl,omitempty"`
+	Limits        *LimitsConfig   `yaml:"limits,omitempty"`
+	Timeouts      TimeoutsConfig  `yaml:"timeouts,omitempty"`
+	Retry         RetryConfig     `yaml:"retry,omitempty"`
+	SlowStart     SlowStartConfig `yaml:"slow_start,omitempty"`
+	Affinity      *AffinityConfig `yaml:"affinity,omitempty"`
+	Failover      FailoverConfig  `yaml:"failover,omitempty"`
+	Locality      LocalityConfig  `yaml:"locality,omitempty"`
+	Splits        []SplitConfig   `yaml:"splits,omitempty"`
+	Mirror        *MirrorConfig   `yaml:"mirror,omitempty"`
+
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
+	ConnectionPool *ConnectionPoolConfig `yaml:"connection_pool,omitempty"`
+	Dialer         *DialerConfig         `yaml:"dialer,omitempty"`
+	Bandwidth      *BandwidthConfig      `yaml:"bandwidth,omitempty"`
+}
+
+// BandwidthConfig limits the bytes per second of each connection, of all
+// connections from one client IP and of all connections to one backend.
+// TableSize bounds how many idle clients and backends are remembered.
+type BandwidthConfig struct {
+	PerConnection BandwidthLimitsConfig `yaml:"per_connection,omitempty"`
+	PerClient     BandwidthLimitsConfig `yaml:"per_client,omitempty"`
+	PerBackend    BandwidthLimitsConfig `yaml:"per_backend,omitempty"`
+	TableSize     int                   `yaml:"table_size,omitempty"`
+}
+
+// BandwidthLimitsConfig limits the bytes sent to the backend (In) and to
+// the client (Out) independently
+type BandwidthLimitsConfig struct {
+	In  ByteRateConfig `yaml:"in,omitempty"`
+	Out ByteRateConfig `yaml:"out,omitempty"`
+}
+
+// ByteRateConfig allows Rate bytes per second with bursts of up to Burst
+// bytes, one second's worth by default. A zero Rate means no limit.
+type ByteRateConfig struct {
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
 }
 
-// BackendConfig represents a backend server configuration
+// DialerConfig binds the TCP connections made to backends, health probes
+// included, to SourceAddress or the network Interface and sets Mark
+// (SO_MARK) on them for policy routing. Interface and Mark require Linux.
+type DialerConfig struct {
+	SourceAddress string `yaml:"source_address,omitempty"`
+	Interface     string `yaml:"interface,omitempty"`
+	Mark          int    `yaml:"mark,omitempty"`
+}
+
+// ConnectionPoolConfig keeps up to MaxSize plain TCP connections per
+// backend (default 100), opening MinIdle of them ahead of time once the
+// backend is healthy so new clients skip the connect. Idle connections are
+// closed after MaxIdleTime and pooled connections after MaxLifetime.
+type ConnectionPoolConfig struct {
+	MaxSize     int           `yaml:"max_size,omitempty"`
+	MinIdle     int           `yaml:"min_idle,omitempty"`
+	MaxIdleTime time.Duration `yaml:"max_idle_time,omitempty"`
+	MaxLifetime time.Duration `yaml:"max_lifetime,omitempty"`
+}
+
+// CircuitBreakerConfig stops selecting a backend after FailureThreshold
+// consecutive dial errors or early connection failures, for OpenDuration,
+// then admits HalfOpenTrials trial connections before closing again. A
+// connection the backend closes within EarlyFailureWindow without sending
+// anything is a failure; a negative window disables that check.
+type CircuitBreakerConfig struct {
+	FailureThreshold   int           `yaml:"failure_threshold,omitempty"`
+	OpenDuration       time.Duration `yaml:"open_duration,omitempty"`
+	HalfOpenTrials     int           `yaml:"half_open_trials,omitempty"`
+	EarlyFailureWindow time.Duration `yaml:"early_failure_window,omitempty"`
+}
+
+// MirrorConfig copies the client bytes of SamplePercent of connections
+// (default 100) to a shadow backend at Address (host:port) and discards its
+// responses. BufferSize bounds the bytes queued per connection; streams
+// that fall further behind are dropped.
+type MirrorConfig struct {
+	Address       string     `yaml:"address"`
+	TLS           *TLSConfig `yaml:"tls,omitempty"`
+	SamplePercent float64    `yaml:"sample_percent,omitempty"`
+	BufferSize    int        `yaml:"buffer_size,omitempty"`
+}
+
+// SplitConfig divides the connections routed to Pool between the variant
+// pools by weight. With Consistent set, each client IP stays on one variant.
+type SplitConfig struct {
+	Name       string               `yaml:"name"`
+	Pool       string               `yaml:"pool"`
+	Consistent bool                 `yaml:"consistent,omitempty"`
+	Variants   []SplitVariantConfig `yaml:"variants"`
+}
+
+// SplitVariantConfig is one destination pool of a split
+type SplitVariantConfig struct {
+	Pool   string `yaml:"pool"`
+	Weight int    `yaml:"weight"`
+}
+
+// LocalityConfig prefers backends in the load balancer's zone. Traffic
+// spills to other zones in proportion once less than MinLocalPercent of the
+// local backends' capacity is available.
+type LocalityConfig struct {
+	MinLocalPercent float64 `yaml:"min_local_percent,omitempty"`
+}
+
+// FailoverConfig decides when a priority group is too degraded to serve
+// traffic alone. A group is used while at least MinHealthy of its backends,
+// and at least MinHealthyPercent of them, are available.
+type FailoverConfig struct {
+	MinHealthy        int     `yaml:"min_healthy,omitempty"`
+	MinHealthyPercent float64 `yaml:"min_healthy_percent,omitempty"`
+}
+
+// AffinityConfig keeps each client IP on the backend it was last sent to
+// until it has been idle for TTL. Size bounds the number of clients
+// remembered.
+type AffinityConfig struct {
+	TTL  time.Duration `yaml:"ttl,omitempty"`
+	Size int           `yaml:"size,omitempty"`
+}
+
+// SlowStartConfig ramps up the weight of backends that just became healthy
+// over Window, from MinWeightPercent of their weight to all of it.
+// Aggression shapes the curve: 1 is linear, above 1 ramps faster early.
+type SlowStartConfig struct {
+	Window           time.Duration `yaml:"window,omitempty"`
+	MinWeightPercent float64       `yaml:"min_weight_percent,omitempty"`
+	Aggression       float64       `yaml:"aggression,omitempty"`
+}
+
+// RetryConfig retries failed backend dials on other backends
+type RetryConfig struct {
+	Attempts            int           `yaml:"attempts,omitempty"`
+	PerTryTimeout       time.Duration `yaml:"per_try_timeout,omitempty"`
+	BudgetPercent       float64       `yaml:"budget_percent,omitempty"`
+	MinRetriesPerSecond float64       `yaml:"min_retries_per_second,omitempty"`
+}
+
+// TimeoutsConfig bounds proxied connections. Zero values disable the idle
+// and lifetime limits and use defaults for the connect timeout and keepalive.
+type TimeoutsConfig struct {
+	Connect     time.Duration   `yaml:"connect,omitempty"`
+	Idle        time.Duration   `yaml:"idle,omitempty"`
+	MaxLifetime time.Duration   `yaml:"max_lifetime,omitempty"`
+	KeepAlive   KeepAliveConfig `yaml:"keepalive,omitempty"`
+}
+
+// KeepAliveConfig sets TCP keepalive on the client and backend legs
+type KeepAliveConfig struct {
+	Disabled bool          `yaml:"disabled,omitempty"`
+	Idle     time.Duration `yaml:"idle,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Count    int           `yaml:"count,omitempty"`
+}
+
+// LimitsConfig limits new and concurrent connections. Zero values disable
+// the respective limit.
+type LimitsConfig struct {
+	ConnRate        float64       `yaml:"conn_rate,omitempty"`
+	ConnBurst       int           `yaml:"conn_burst,omitempty"`
+	PrefixConnRate  float64       `yaml:"prefix_conn_rate,omitempty"`
+	PrefixConnBurst int           `yaml:"prefix_conn_burst,omitempty"`
+	IPv4PrefixLen   int           `yaml:"ipv4_prefix_len,omitempty"`
+	IPv6PrefixLen   int           `yaml:"ipv6_prefix_len,omitempty"`
+	MaxPerSource    int           `yaml:"max_per_source,omitempty"`
+	MaxConnections  int           `yaml:"max_connections,omitempty"`
+	OnLimit         string        `yaml:"on_limit,omitempty"` // "reject" or "queue"
+	QueueTimeout    time.Duration `yaml:"queue_timeout,omitempty"`
+	QueueSize       int           `yaml:"queue_size,omitempty"`
+	TableSize       int           `yaml:"table_size,omitempty"`
+}
+
+// ACLConfig restricts which client addresses may connect. Entries are CIDRs
+// or bare IPs; File holds additional "allow <cidr>" / "deny <cidr>" lines
+// and is reloaded when it changes.
+type ACLConfig struct {
+	Allow          []string      `yaml:"allow,omitempty"`
+	Deny           []string      `yaml:"deny,omitempty"`
+	File           string        `yaml:"file,omitempty"`
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+}
+
+// PoolConfig describes an additional named pool of backends. The top-level
+// backends form the pool named "default".
+type PoolConfig struct {
+	Name      string          `yaml:"name"`
+	Algorithm string          `yaml:"algorithm"`
+	Backends  []BackendConfig `yaml:"backends"`
+	Timeouts  TimeoutsConfig  `yaml:"timeouts,omitempty"`
+	Retry     RetryConfig     `yaml:"retry,omitempty"`
+	SlowStart SlowStartConfig `yaml:"slow_start,omitempty"`
+	Affinity  *AffinityConfig `yaml:"affinity,omitempty"`
+	Failover  FailoverConfig  `yaml:"failover,omitempty"`
+	Locality  LocalityConfig  `yaml:"locality,omitempty"`
+	Mirror    *MirrorConfig   `yaml:"mirror,omitempty"`
+
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
+	ConnectionPool *ConnectionPoolConfig `yaml:"connection_pool,omitempty"`
+	Dialer         *DialerConfig         `yaml:"dialer,omitempty"`
+	Bandwidth      *BandwidthConfig      `yaml:"bandwidth,omitempty"`
+}
+
+// SniffingConfig routes connections to pools based on their first bytes
+type SniffingConfig struct {
+	PeekTimeout time.Duration     `yaml:"peek_timeout"`
+	DefaultPool string            `yaml:"default_pool"`
+	Rules       []SniffRuleConfig `yaml:"rules"`
+}
+
+// SniffRuleConfig matches a built-in protocol ("tls", "ssh", "http"), a
+// literal prefix or a regular expression and names the pool to use
+type SniffRuleConfig struct {
+	Protocol string `yaml:"protocol,omitempty"`
+	Prefix   string `yaml:"prefix,omitempty"`
+	Regex    string `yaml:"regex,omitempty"`
+	Pool     string `yaml:"pool"`
+}
+
+// BackendConfig represents a backend server configuration. Address is a
+// host, or a full unix:///path/to.sock or tcp://host:port address with no
+// Port.
 type BackendConfig struct {
-	Address string `yaml:"address"`
-	Port    int    `yaml:"port"`
+	Address string     `yaml:"address" json:"address"`
+	Port    int        `yaml:"port" json:"port"`
+	Weight  int        `yaml:"weight,omitempty" json:"weight,omitempty"`
+	TLS     *TLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+
+	// Priority groups backends; lower values are preferred and higher
+	// values are backups
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+
+	// Zone is the availability zone the backend runs in
+	Zone string `yaml:"zone,omitempty" json:"zone,omitempty"`
 }
 
 // HealthCheckConfig contains health check settings
@@ -48,6 +331,33 @@ func LoadConfig(filePath string) (*Config, error) {
 	return &config, nil
 }
 
+// SaveConfig writes the configuration to a YAML file. The file is replaced
+// atomically so readers never see a partial write.
+func SaveConfig(filePath string, config *Config) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
 // GetDefaultConfig returns a default configuration
 func GetDefaultConfig() *Config {
 	return &Config{
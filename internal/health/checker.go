@@ -1,7 +1,8 @@
 package health
 
 import (
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"l4-load-balancer/internal/backend"
@@ -9,20 +10,38 @@ import (
 
 // Checker performs health checks on backend servers
 type Checker struct {
+	pool     string
 	manager  *backend.Manager
 	interval time.Duration
 	timeout  time.Duration
 	stopCh   chan struct{}
+
+	// metricsMu orders recording a probe against deleting the series of a
+	// removed server, so a probe finishing late can't bring them back
+	metricsMu sync.Mutex
 }
 
-// NewChecker creates a new health checker
+// DefaultPool labels the metrics of checkers created by NewChecker
+const DefaultPool = "default"
+
+// NewChecker creates a health checker for the manager's servers, labeling
+// its metrics with DefaultPool
 func NewChecker(manager *backend.Manager, interval, timeout time.Duration) *Checker {
-	return &Checker{
+	return NewPoolChecker(DefaultPool, manager, interval, timeout)
+}
+
+// NewPoolChecker creates a health checker for the servers of the named
+// pool. It drops a server's health metrics when the server is removed.
+func NewPoolChecker(pool string, manager *backend.Manager, interval, timeout time.Duration) *Checker {
+	c := &Checker{
+		pool:     pool,
 		manager:  manager,
 		interval: interval,
 		timeout:  timeout,
 		stopCh:   make(chan struct{}),
 	}
+	manager.AddObserver(c)
+	return c
 }
 
 // Start begins the health checking process
@@ -38,7 +57,7 @@ func (c *Checker) Start() {
 		case <-ticker.C:
 			c.checkAll()
 		case <-c.stopCh:
-			log.Println("Health checker stopped")
+			slog.Info("Health checker stopped")
 			return
 		}
 	}
@@ -49,6 +68,16 @@ func (c *Checker) Stop() {
 	close(c.stopCh)
 }
 
+// CheckNow performs an immediate health check of all servers
+func (c *Checker) CheckNow() {
+	c.checkAll()
+}
+
+// CheckServer performs an immediate health check of a single server
+func (c *Checker) CheckServer(server *backend.Server) {
+	c.checkServer(server)
+}
+
 // checkAll performs health checks on all servers
 func (c *Checker) checkAll() {
 	servers := c.manager.GetAllServers()
@@ -60,17 +89,50 @@ func (c *Checker) checkAll() {
 
 // checkServer performs a health check on a single server
 func (c *Checker) checkServer(server *backend.Server) {
-	server.LastChecked = time.Now()
+	start := time.Now()
+	reachable := server.IsReachableWithin(c.timeout)
+	c.observe(server, reachable, time.Since(start))
 
-	if server.IsReachable() {
-		if !server.Healthy {
-			log.Printf("Server %s is now healthy", server.GetAddress())
-		}
-		server.Healthy = true
+	if !server.SetCheckResult(reachable, start) {
+		return
+	}
+	if server.IsHealthy() {
+		slog.Info("Server is now healthy", "backend", server.GetAddress())
 	} else {
-		if server.Healthy {
-			log.Printf("Server %s is now unhealthy", server.GetAddress())
-		}
-		server.Healthy = false
+		slog.Warn("Server is now unhealthy", "backend", server.GetAddress())
+	}
+}
+
+// observe records the outcome of a probe in the health metrics, unless the
+// server was removed meanwhile
+func (c *Checker) observe(server *backend.Server, healthy bool, took time.Duration) {
+	result, up := "failure", 0.0
+	if healthy {
+		result, up = "success", 1.0
 	}
+	address := server.GetAddress()
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+	if c.manager.GetServer(address) != server {
+		return
+	}
+	checksTotal.With(c.pool, address, result).Inc()
+	checkDuration.With(c.pool, address).Observe(took.Seconds())
+	backendUp.With(c.pool, address).Set(up)
 }
+
+// ServerAdded does nothing; a new server is reported on its first check
+func (c *Checker) ServerAdded(server *backend.Server) {}
+
+// ServerRemoved deletes the server's health metrics
+func (c *Checker) ServerRemoved(server *backend.Server) {
+	address := server.GetAddress()
+	c.metricsMu.Lock()
+	defer c.metricsMu.Unlock()
+	checksTotal.DeletePrefix(c.pool, address)
+	checkDuration.Delete(c.pool, address)
+	backendUp.Delete(c.pool, address)
+}
+
+// HealthChanged does nothing; the checker is what changes health
+func (c *Checker) HealthChanged(server *backend.Server, healthy bool) {}
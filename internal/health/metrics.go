@@ -0,0 +1,21 @@
+package health
+
+import (
+	"l4-load-balancer/internal/metrics"
+)
+
+var (
+	checksTotal = metrics.NewCounterVec(
+		"lb_health_checks_total",
+		"Health checks performed, by result.",
+		"pool", "backend", "result")
+	checkDuration = metrics.NewHistogramVec(
+		"lb_health_check_duration_seconds",
+		"Time taken by health check probes.",
+		metrics.DefaultLatencyBuckets,
+		"pool", "backend")
+	backendUp = metrics.NewGaugeVec(
+		"lb_backend_up",
+		"Whether the backend passed its last health check (1) or not (0).",
+		"pool", "backend")
+)
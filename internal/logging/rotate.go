@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Rotation defaults
+const (
+	DefaultMaxSize    = 100 * 1024 * 1024
+	DefaultMaxBackups = 5
+)
+
+// RotatingFile is an append-only log file that is rotated once it reaches
+// a maximum size: path is renamed to path.1, path.1 to path.2 and so on,
+// keeping at most maxBackups old files. Reopen supports external rotation.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating it if needed
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating first if p would take the file past its
+// maximum size
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens path again, for when it has been moved
+// away by an external log rotator
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	return f.open()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens path for appending. Callers hold f.mu.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		f.file = nil
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups along and starts a new file. If path can't be
+// renamed, writing carries on in the current file. Callers hold f.mu.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+	}
+	os.Rename(f.path, backupName(f.path, 1))
+	return f.open()
+}
+
+// backupName returns the name of the nth most recent backup of path
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
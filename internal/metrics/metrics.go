@@ -0,0 +1,373 @@
+package metrics
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultDurationBuckets suit connection durations, from milliseconds to an hour
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// DefaultLatencyBuckets suit short operations such as dials and health probes
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// atomicFloat is a float64 updated atomically
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := f.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if f.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add increases the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.value.add(v)
+	}
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	return c.value.load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value atomicFloat
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+// Add adds v to the gauge
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	return g.value.load()
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Uint64
+	sum    atomicFloat
+	count  atomic.Uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)),
+	}
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.sum.add(v)
+	h.count.Add(1)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// vec holds one metric per combination of label values
+type vec[T any] struct {
+	name     string
+	help     string
+	labels   []string
+	newChild func() *T
+
+	mu       sync.RWMutex
+	children map[string]*labeled[T]
+}
+
+type labeled[T any] struct {
+	values []string
+	metric *T
+}
+
+func newVec[T any](name, help string, labels []string, newChild func() *T) *vec[T] {
+	return &vec[T]{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		newChild: newChild,
+		children: make(map[string]*labeled[T]),
+	}
+}
+
+// with returns the metric for the label values, creating it on first use
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + ": wrong number of label values")
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child.metric
+	}
+	child = &labeled[T]{values: append([]string(nil), values...), metric: v.newChild()}
+	v.children[key] = child
+	return child.metric
+}
+
+// put replaces the metric for the label values
+func (v *vec[T]) put(metric *T, values ...string) {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + ": wrong number of label values")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.children[strings.Join(values, "\xff")] = &labeled[T]{values: append([]string(nil), values...), metric: metric}
+}
+
+// delete removes the metric for the label values
+func (v *vec[T]) delete(values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, strings.Join(values, "\xff"))
+}
+
+// deletePrefix removes the metrics whose leading label values are values
+func (v *vec[T]) deletePrefix(values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, child := range v.children {
+		if len(child.values) >= len(values) && slices.Equal(child.values[:len(values)], values) {
+			delete(v.children, key)
+		}
+	}
+}
+
+// each calls fn for every child in a stable order
+func (v *vec[T]) each(fn func(values []string, metric *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	children := make([]*labeled[T], 0, len(keys))
+	sort.Strings(keys)
+	for _, k := range keys {
+		children = append(children, v.children[k])
+	}
+	v.mu.RUnlock()
+
+	for _, child := range children {
+		fn(child.values, child.metric)
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec creates a counter family in the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec creates a counter family in the registry
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	r.MustRegister(c)
+	return c
+}
+
+// With returns the counter for the label values
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values...)
+}
+
+// Delete removes the counter for the label values
+func (c *CounterVec) Delete(values ...string) {
+	c.delete(values...)
+}
+
+// DeletePrefix removes the counters whose leading label values are values,
+// whatever their remaining labels
+func (c *CounterVec) DeletePrefix(values ...string) {
+	c.deletePrefix(values...)
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGaugeVec creates a gauge family in the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec creates a gauge family in the registry
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labels, func() *Gauge { return &Gauge{} })}
+	r.MustRegister(g)
+	return g
+}
+
+// With returns the gauge for the label values
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values...)
+}
+
+// Delete removes the gauge for the label values
+func (g *GaugeVec) Delete(values ...string) {
+	g.delete(values...)
+}
+
+// DeletePrefix removes the gauges whose leading label values are values,
+// whatever their remaining labels
+func (g *GaugeVec) DeletePrefix(values ...string) {
+	g.deletePrefix(values...)
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	*vec[Histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates a histogram family with the given upper bucket
+// bounds in the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec creates a histogram family with the given upper bucket
+// bounds in the registry
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{
+		vec:     newVec(name, help, labels, func() *Histogram { return newHistogram(bounds) }),
+		buckets: bounds,
+	}
+	r.MustRegister(h)
+	return h
+}
+
+// With returns the histogram for the label values
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values...)
+}
+
+// Delete removes the histogram for the label values
+func (h *HistogramVec) Delete(values ...string) {
+	h.delete(values...)
+}
+
+// DeletePrefix removes the histograms whose leading label values are
+// values, whatever their remaining labels
+func (h *HistogramVec) DeletePrefix(values ...string) {
+	h.deletePrefix(values...)
+}
+
+// FuncVec is a family of counters or gauges whose values are read from
+// callbacks at scrape time, for state owned by other components
+type FuncVec struct {
+	*vec[func() float64]
+	kind string
+}
+
+// NewGaugeFuncVec creates a callback-backed gauge family in the default
+// registry
+func NewGaugeFuncVec(name, help string, labels ...string) *FuncVec {
+	return DefaultRegistry.NewGaugeFuncVec(name, help, labels...)
+}
+
+// NewCounterFuncVec creates a callback-backed counter family in the default
+// registry
+func NewCounterFuncVec(name, help string, labels ...string) *FuncVec {
+	return DefaultRegistry.NewCounterFuncVec(name, help, labels...)
+}
+
+// NewGaugeFuncVec creates a callback-backed gauge family in the registry
+func (r *Registry) NewGaugeFuncVec(name, help string, labels ...string) *FuncVec {
+	return r.newFuncVec("gauge", name, help, labels)
+}
+
+// NewCounterFuncVec creates a callback-backed counter family in the registry
+func (r *Registry) NewCounterFuncVec(name, help string, labels ...string) *FuncVec {
+	return r.newFuncVec("counter", name, help, labels)
+}
+
+func (r *Registry) newFuncVec(kind, name, help string, labels []string) *FuncVec {
+	f := &FuncVec{
+		vec:  newVec(name, help, labels, func() *func() float64 { return new(func() float64) }),
+		kind: kind,
+	}
+	r.MustRegister(f)
+	return f
+}
+
+// Set installs the callback for the label values
+func (f *FuncVec) Set(fn func() float64, values ...string) {
+	f.put(&fn, values...)
+}
+
+// Delete removes the callback for the label values
+func (f *FuncVec) Delete(values ...string) {
+	f.delete(values...)
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests handled.", "code")
+	inflight := r.NewGaugeVec("test_inflight", "In-flight requests.")
+	latency := r.NewHistogramVec("test_latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	pool := r.NewGaugeFuncVec("test_pool_size", "Pool size.", "address")
+
+	requests.With("200").Add(3)
+	requests.With("500").Inc()
+	inflight.With().Set(2)
+	latency.With("/a").Observe(0.05)
+	latency.With("/a").Observe(0.5)
+	latency.With("/a").Observe(5)
+	pool.Set(func() float64 { return 7 }, `a"b\c`)
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_inflight In-flight requests.
+# TYPE test_inflight gauge
+test_inflight 2
+# HELP test_latency_seconds Request latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/a",le="0.1"} 1
+test_latency_seconds_bucket{route="/a",le="1"} 2
+test_latency_seconds_bucket{route="/a",le="+Inf"} 3
+test_latency_seconds_sum{route="/a"} 5.55
+test_latency_seconds_count{route="/a"} 3
+# HELP test_pool_size Pool size.
+# TYPE test_pool_size gauge
+test_pool_size{address="a\"b\\c"} 7
+# HELP test_requests_total Requests handled.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 3
+test_requests_total{code="500"} 1
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestVec_Delete(t *testing.T) {
+	r := NewRegistry()
+	errors := r.NewCounterVec("test_errors_total", "Errors.", "pool", "backend", "type")
+	errors.With("web", "a:80", "refused").Inc()
+	errors.With("web", "a:80", "timeout").Inc()
+	errors.With("web", "b:80", "refused").Inc()
+	errors.With("api", "a:80", "refused").Inc()
+
+	errors.Delete("web", "b:80", "refused")
+	errors.DeletePrefix("web", "a:80")
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_errors_total Errors.
+# TYPE test_errors_total counter
+test_errors_total{pool="api",backend="a:80",type="refused"} 1
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("dup_total", "First.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected duplicate registration to panic")
+		}
+	}()
+	r.NewGaugeVec("dup_total", "Second.")
+}
+
+func TestCounter_Concurrency(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("concurrent_total", "Concurrent increments.", "worker")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				counter.With("shared").Inc()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v := counter.With("shared").Value(); v != 50000 {
+		t.Errorf("Expected 50000, got %v", v)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("handler_total", "Handler test.").With().Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "handler_total 1\n") {
+		t.Errorf("Expected counter in body, got:\n%s", body)
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import "l4-load-balancer/pkg/pool"
+
+var (
+	connectionPoolActive = NewGaugeFuncVec(
+		"lb_connection_pool_active_connections",
+		"Connections created by the connection pool and not yet closed.",
+		"pool", "address")
+	connectionPoolIdle = NewGaugeFuncVec(
+		"lb_connection_pool_idle_connections",
+		"Idle connections waiting in the connection pool.",
+		"pool", "address")
+	connectionPoolHits = NewCounterFuncVec(
+		"lb_connection_pool_hits_total",
+		"Connection requests served by an idle pooled connection.",
+		"pool", "address")
+	connectionPoolMisses = NewCounterFuncVec(
+		"lb_connection_pool_misses_total",
+		"Connection requests that dialed a new connection.",
+		"pool", "address")
+	connectionPoolEvictions = NewCounterFuncVec(
+		"lb_connection_pool_evictions_total",
+		"Idle connections closed as expired or closed by the backend.",
+		"pool", "address")
+	connectionPoolDialErrors = NewCounterFuncVec(
+		"lb_connection_pool_dial_errors_total",
+		"Failed attempts to dial a pooled connection.",
+		"pool", "address")
+	connectionPoolWaiting = NewGaugeFuncVec(
+		"lb_connection_pool_waiting",
+		"Callers queued for a connection from a full pool.",
+		"pool", "address")
+	connectionPoolWaits = NewCounterFuncVec(
+		"lb_connection_pool_waits_total",
+		"Connection requests that queued because the pool was full.",
+		"pool", "address")
+	connectionPoolWaitTimeouts = NewCounterFuncVec(
+		"lb_connection_pool_wait_timeouts_total",
+		"Queued connection requests whose deadline passed first.",
+		"pool", "address")
+	connectionPoolWaitSeconds = NewCounterFuncVec(
+		"lb_connection_pool_wait_seconds_total",
+		"Total time connection requests spent queued.",
+		"pool", "address")
+)
+
+// ConnectionPoolStats is implemented by pool.ConnectionPool
+type ConnectionPoolStats interface {
+	Stats() pool.Stats
+}
+
+// ObserveConnectionPool exports the sizes and counters of the connection
+// pool to address kept for the load balancer pool named poolName
+func ObserveConnectionPool(poolName, address string, p ConnectionPoolStats) {
+	stat := func(field func(pool.Stats) float64) func() float64 {
+		return func() float64 { return field(p.Stats()) }
+	}
+	connectionPoolActive.Set(stat(func(s pool.Stats) float64 { return float64(s.Active) }), poolName, address)
+	connectionPoolIdle.Set(stat(func(s pool.Stats) float64 { return float64(s.Idle) }), poolName, address)
+	connectionPoolHits.Set(stat(func(s pool.Stats) float64 { return float64(s.Hits) }), poolName, address)
+	connectionPoolMisses.Set(stat(func(s pool.Stats) float64 { return float64(s.Misses) }), poolName, address)
+	connectionPoolEvictions.Set(stat(func(s pool.Stats) float64 { return float64(s.Evictions) }), poolName, address)
+	connectionPoolDialErrors.Set(stat(func(s pool.Stats) float64 { return float64(s.DialErrors) }), poolName, address)
+	connectionPoolWaiting.Set(stat(func(s pool.Stats) float64 { return float64(s.Waiting) }), poolName, address)
+	connectionPoolWaits.Set(stat(func(s pool.Stats) float64 { return float64(s.Waits) }), poolName, address)
+	connectionPoolWaitTimeouts.Set(stat(func(s pool.Stats) float64 { return float64(s.WaitTimeouts) }), poolName, address)
+	connectionPoolWaitSeconds.Set(stat(func(s pool.Stats) float64 { return s.WaitTime.Seconds() }), poolName, address)
+}
+
+// ForgetConnectionPool stops exporting the connection pool to address kept
+// for the load balancer pool named poolName
+func ForgetConnectionPool(poolName, address string) {
+	for _, f := range []*FuncVec{
+		connectionPoolActive, connectionPoolIdle, connectionPoolHits,
+		connectionPoolMisses, connectionPoolEvictions, connectionPoolDialErrors,
+		connectionPoolWaiting, connectionPoolWaits, connectionPoolWaitTimeouts,
+		connectionPoolWaitSeconds,
+	} {
+		f.Delete(poolName, address)
+	}
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultRegistry holds the metrics created with the New* constructors
+var DefaultRegistry = NewRegistry()
+
+// collector writes one metric family in the text exposition format
+type collector interface {
+	familyName() string
+	writeTo(w *bufio.Writer)
+}
+
+// Registry is a set of metric families exposed together
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// MustRegister adds a metric family, panicking if the name is taken
+func (r *Registry) MustRegister(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := c.familyName()
+	if _, exists := r.collectors[name]; exists {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.collectors[name] = c
+}
+
+// WriteText writes all metric families in the Prometheus text exposition
+// format, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.writeTo(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry's metrics over HTTP
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Handler serves the default registry's metrics over HTTP
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+func (v *vec[T]) familyName() string {
+	return v.name
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.each(func(values []string, counter *Counter) {
+		writeSample(w, c.name, c.labels, values, "", "", counter.Value())
+	})
+}
+
+func (g *GaugeVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	g.each(func(values []string, gauge *Gauge) {
+		writeSample(w, g.name, g.labels, values, "", "", gauge.Value())
+	})
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.each(func(values []string, hist *Histogram) {
+		var cumulative uint64
+		for i, bound := range hist.bounds {
+			cumulative += hist.counts[i].Load()
+			writeSample(w, h.name+"_bucket", h.labels, values, "le", formatFloat(bound), float64(cumulative))
+		}
+		count := hist.count.Load()
+		writeSample(w, h.name+"_bucket", h.labels, values, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values, "", "", hist.sum.load())
+		writeSample(w, h.name+"_count", h.labels, values, "", "", float64(count))
+	})
+}
+
+func (f *FuncVec) writeTo(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	f.each(func(values []string, fn *func() float64) {
+		if *fn != nil {
+			writeSample(w, f.name, f.labels, values, "", "", (*fn)())
+		}
+	})
+}
+
+// writeSample writes one sample line, optionally with an extra label such as
+// a histogram's "le"
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Direction is the way bytes flow through a proxied connection
+type Direction int
+
+// Directions, named from the backend's point of view like the proxy's byte
+// counters
+const (
+	In  Direction = iota // client to backend
+	Out                  // backend to client
+)
+
+// String returns "in" or "out"
+func (d Direction) String() string {
+	if d == Out {
+		return "out"
+	}
+	return "in"
+}
+
+// BandwidthScope names what a bandwidth limit is shared by
+type BandwidthScope string
+
+// Bandwidth scopes
+const (
+	ScopeConnection BandwidthScope = "connection"
+	ScopeClient     BandwidthScope = "client"
+	ScopeBackend    BandwidthScope = "backend"
+)
+
+// ByteRate limits a byte stream to Rate bytes per second with bursts of up
+// to Burst bytes. A zero Rate disables the limit; Burst defaults to one
+// second's worth of Rate.
+type ByteRate struct {
+	Rate  float64
+	Burst int
+}
+
+// enabled reports whether the rate limits anything
+func (r ByteRate) enabled() bool {
+	return r.Rate > 0
+}
+
+// burst returns the configured burst or its default
+func (r ByteRate) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return max(int(r.Rate), 1)
+}
+
+func (r ByteRate) bucket(now time.Time) *TokenBucket {
+	return NewTokenBucket(r.Rate, r.burst(), now)
+}
+
+// BandwidthLimits holds independent limits for each direction
+type BandwidthLimits struct {
+	In  ByteRate
+	Out ByteRate
+}
+
+// get returns the limit for dir
+func (l BandwidthLimits) get(dir Direction) ByteRate {
+	if dir == Out {
+		return l.Out
+	}
+	return l.In
+}
+
+// BandwidthConfig holds byte rate limits for each connection, for all
+// connections of a client IP and for all connections to a backend
+type BandwidthConfig struct {
+	PerConnection BandwidthLimits
+	PerClient     BandwidthLimits
+	PerBackend    BandwidthLimits
+
+	// TableSize bounds how many clients and backends are remembered. While a
+	// table is full of ones with active connections, new connections from
+	// other clients or to other backends aren't held to that shared limit.
+	TableSize int
+}
+
+// Throttle enforces bandwidth limits on proxied connections. Client and
+// backend buckets are shared by all their connections and remembered for a
+// while after the last one closes, so reconnecting doesn't reset them.
+type Throttle struct {
+	cfg BandwidthConfig
+
+	mu       sync.Mutex
+	clients  [2]*table
+	backends [2]*table
+}
+
+// NewThrottle creates a throttle enforcing cfg
+func NewThrottle(cfg BandwidthConfig) *Throttle {
+	if cfg.TableSize <= 0 {
+		cfg.TableSize = DefaultTableSize
+	}
+	t := &Throttle{cfg: cfg}
+	for _, dir := range []Direction{In, Out} {
+		t.clients[dir] = newTable(cfg.TableSize)
+		t.backends[dir] = newTable(cfg.TableSize)
+	}
+	return t
+}
+
+// Open starts throttling a connection from client to backend. The
+// returned ConnThrottle must be closed when the connection ends. If the
+// client or backend table is full of entries with active connections, that
+// limit isn't applied to the connection; see ConnThrottle.Untracked.
+func (t *Throttle) Open(client netip.Addr, backend string) *ConnThrottle {
+	now := time.Now()
+	c := &ConnThrottle{throttle: t}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, dir := range []Direction{In, Out} {
+		if r := t.cfg.PerConnection.get(dir); r.enabled() {
+			c.conn[dir] = r.bucket(now)
+			c.chunk[dir] = r.burst()
+		}
+		if r := t.cfg.PerClient.get(dir); r.enabled() && client.IsValid() {
+			if e := t.clients[dir].get(client.String(), func() *TokenBucket { return r.bucket(now) }); e != nil {
+				c.client[dir] = e
+				t.clients[dir].acquire(e)
+				c.chunk[dir] = minChunk(c.chunk[dir], r.burst())
+			} else {
+				c.untrack(ScopeClient)
+			}
+		}
+		if r := t.cfg.PerBackend.get(dir); r.enabled() {
+			if e := t.backends[dir].get(backend, func() *TokenBucket { return r.bucket(now) }); e != nil {
+				c.backend[dir] = e
+				t.backends[dir].acquire(e)
+				c.chunk[dir] = minChunk(c.chunk[dir], r.burst())
+			} else {
+				c.untrack(ScopeBackend)
+			}
+		}
+	}
+	return c
+}
+
+// TrackedSources returns how many clients and backends have bucket state
+func (t *Throttle) TrackedSources() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, dir := range []Direction{In, Out} {
+		n += t.clients[dir].len() + t.backends[dir].len()
+	}
+	return n
+}
+
+func minChunk(chunk, burst int) int {
+	if chunk == 0 {
+		return burst
+	}
+	return min(chunk, burst)
+}
+
+// ConnThrottle applies a Throttle's limits to one connection. Each
+// direction may be used from its own goroutine.
+type ConnThrottle struct {
+	throttle *Throttle
+	conn     [2]*TokenBucket
+	client   [2]*entry
+	backend  [2]*entry
+	chunk    [2]int
+	closed   bool
+
+	untracked []BandwidthScope
+}
+
+// Untracked returns the shared limits that aren't applied to the connection
+// because their table was full of clients or backends with active
+// connections
+func (c *ConnThrottle) Untracked() []BandwidthScope {
+	return c.untracked
+}
+
+func (c *ConnThrottle) untrack(scope BandwidthScope) {
+	if !slices.Contains(c.untracked, scope) {
+		c.untracked = append(c.untracked, scope)
+	}
+}
+
+// Chunk returns the most bytes to read at once in dir so that a single
+// read stays within the smallest burst, or zero if dir isn't limited
+func (c *ConnThrottle) Chunk(dir Direction) int {
+	return c.chunk[dir]
+}
+
+// Reserve charges n bytes in dir to every applicable limit and returns how
+// long to wait before sending them, along with the limit that imposed the
+// wait
+func (c *ConnThrottle) Reserve(dir Direction, n int) (time.Duration, BandwidthScope) {
+	now := time.Now()
+	var delay time.Duration
+	var scope BandwidthScope
+	if b := c.conn[dir]; b != nil {
+		if d := b.Reserve(float64(n), now); d > delay {
+			delay, scope = d, ScopeConnection
+		}
+	}
+	if c.client[dir] == nil && c.backend[dir] == nil {
+		return delay, scope
+	}
+
+	c.throttle.mu.Lock()
+	defer c.throttle.mu.Unlock()
+	if e := c.client[dir]; e != nil {
+		if d := e.bucket.Reserve(float64(n), now); d > delay {
+			delay, scope = d, ScopeClient
+		}
+	}
+	if e := c.backend[dir]; e != nil {
+		if d := e.bucket.Reserve(float64(n), now); d > delay {
+			delay, scope = d, ScopeBackend
+		}
+	}
+	return delay, scope
+}
+
+// Close stops throttling the connection, letting the client and backend
+// state be forgotten once it has no other connections and its buckets
+// have refilled
+func (c *ConnThrottle) Close() {
+	now := time.Now()
+	c.throttle.mu.Lock()
+	defer c.throttle.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, dir := range []Direction{In, Out} {
+		if e := c.client[dir]; e != nil {
+			c.throttle.clients[dir].release(e, now)
+		}
+		if e := c.backend[dir]; e != nil {
+			c.throttle.backends[dir].release(e, now)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// about reports whether d is within 10% below want; time passing between
+// calls only shortens waits
+func about(d, want time.Duration) bool {
+	return d <= want && d >= want-want/10
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	b := NewTokenBucket(100, 10, now)
+
+	if wait := b.Reserve(10, now); wait != 0 {
+		t.Errorf("Expected the burst to be available, got a %v wait", wait)
+	}
+	if wait := b.Reserve(20, now); wait != 200*time.Millisecond {
+		t.Errorf("Expected a 200ms wait, got %v", wait)
+	}
+	if wait := b.Reserve(1, now.Add(200*time.Millisecond)); wait != 10*time.Millisecond {
+		t.Errorf("Expected the debt to be repaid, leaving a 10ms wait, got %v", wait)
+	}
+}
+
+func TestThrottle_PerConnection(t *testing.T) {
+	th := NewThrottle(BandwidthConfig{
+		PerConnection: BandwidthLimits{In: ByteRate{Rate: 1000, Burst: 500}},
+	})
+	c := th.Open(netip.MustParseAddr("192.0.2.1"), "10.0.0.1:80")
+	defer c.Close()
+
+	if c.Chunk(In) != 500 || c.Chunk(Out) != 0 {
+		t.Errorf("Expected chunks of 500 in and unlimited out, got %d and %d", c.Chunk(In), c.Chunk(Out))
+	}
+	if wait, _ := c.Reserve(In, 500); wait != 0 {
+		t.Errorf("Expected the burst to pass, got a %v wait", wait)
+	}
+	if wait, scope := c.Reserve(In, 250); !about(wait, 250*time.Millisecond) || scope != ScopeConnection {
+		t.Errorf("Expected a 250ms connection wait, got %v from %q", wait, scope)
+	}
+	if wait, _ := c.Reserve(Out, 1<<20); wait != 0 {
+		t.Errorf("Expected the other direction to be unlimited, got a %v wait", wait)
+	}
+
+	// A new connection gets its own bucket
+	other := th.Open(netip.MustParseAddr("192.0.2.1"), "10.0.0.1:80")
+	defer other.Close()
+	if wait, _ := other.Reserve(In, 500); wait != 0 {
+		t.Errorf("Expected a new connection to have its own burst, got a %v wait", wait)
+	}
+}
+
+func TestThrottle_SharedLimits(t *testing.T) {
+	th := NewThrottle(BandwidthConfig{
+		PerClient:  BandwidthLimits{Out: ByteRate{Rate: 1000}},
+		PerBackend: BandwidthLimits{Out: ByteRate{Rate: 2000}},
+	})
+	client := netip.MustParseAddr("192.0.2.1")
+	first := th.Open(client, "10.0.0.1:80")
+	second := th.Open(client, "10.0.0.1:80")
+	stranger := th.Open(netip.MustParseAddr("192.0.2.2"), "10.0.0.1:80")
+
+	if c := first.Chunk(Out); c != 1000 {
+		t.Errorf("Expected the smallest default burst of 1000 as chunk, got %d", c)
+	}
+	if wait, _ := first.Reserve(Out, 1000); wait != 0 {
+		t.Errorf("Expected the client burst to pass, got a %v wait", wait)
+	}
+	if wait, scope := second.Reserve(Out, 500); !about(wait, 500*time.Millisecond) || scope != ScopeClient {
+		t.Errorf("Expected a 500ms client wait shared across connections, got %v from %q", wait, scope)
+	}
+
+	// Another client has its own bucket but shares the backend's, which
+	// has already seen 1500 of its 2000 byte burst
+	if wait, scope := stranger.Reserve(Out, 1000); !about(wait, 250*time.Millisecond) || scope != ScopeBackend {
+		t.Errorf("Expected a 250ms backend wait, got %v from %q", wait, scope)
+	}
+
+	first.Close()
+	first.Close()
+	second.Close()
+	stranger.Close()
+	if n := th.TrackedSources(); n != 3 {
+		t.Errorf("Expected 2 clients and 1 backend with debt to be remembered, got %d", n)
+	}
+
+	idle := th.Open(netip.MustParseAddr("192.0.2.3"), "10.0.0.2:80")
+	idle.Close()
+	if n := th.TrackedSources(); n != 3 {
+		t.Errorf("Expected full buckets to be forgotten, got %d tracked", n)
+	}
+}
+
+func TestThrottle_FullTable(t *testing.T) {
+	th := NewThrottle(BandwidthConfig{
+		PerClient:  BandwidthLimits{In: ByteRate{Rate: 1000}, Out: ByteRate{Rate: 1000}},
+		PerBackend: BandwidthLimits{Out: ByteRate{Rate: 2000}},
+		TableSize:  1,
+	})
+	first := th.Open(netip.MustParseAddr("192.0.2.1"), "10.0.0.1:80")
+	defer first.Close()
+
+	// The client table is full of a client with an active connection, so
+	// the second client isn't held to a client limit
+	second := th.Open(netip.MustParseAddr("192.0.2.2"), "10.0.0.1:80")
+	if scopes := second.Untracked(); len(scopes) != 1 || scopes[0] != ScopeClient {
+		t.Errorf("Expected only the client limit to be untracked, got %v", scopes)
+	}
+	if wait, _ := second.Reserve(In, 5000); wait != 0 {
+		t.Errorf("Expected no limit on the untracked direction, got a %v wait", wait)
+	}
+	if wait, scope := second.Reserve(Out, 3000); !about(wait, 500*time.Millisecond) || scope != ScopeBackend {
+		t.Errorf("Expected the backend limit to still apply, got %v from %q", wait, scope)
+	}
+	second.Close()
+
+	if scopes := first.Untracked(); len(scopes) != 0 {
+		t.Errorf("Expected every limit to apply to the first connection, got %v untracked", scopes)
+	}
+}
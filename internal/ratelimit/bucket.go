@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// TokenBucket is a token bucket refilled at a fixed rate up to its burst
+// size. It is not safe for concurrent use; callers hold their own lock.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket allowing rate events per second with
+// bursts of up to burst events
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Delay returns how long until n tokens are available, or zero if they
+// are available now
+func (b *TokenBucket) Delay(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= n {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	missing := n - b.tokens
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// Take consumes n tokens if they are available
+func (b *TokenBucket) Take(n float64, now time.Time) bool {
+	if b.Delay(n, now) > 0 {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Reserve consumes n tokens, going into debt if there aren't enough, and
+// returns how long until the debt is repaid. Callers wait that long before
+// acting.
+func (b *TokenBucket) Reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Full reports whether the bucket has refilled completely, meaning it holds
+// no state worth keeping
+func (b *TokenBucket) Full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
@@ -0,0 +1,395 @@
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"l4-load-balancer/pkg/netutil"
+)
+
+// DefaultTableSize bounds the number of sources tracked when not configured
+const DefaultTableSize = 65536
+
+// DefaultQueueSize bounds the number of connections waiting for a per-source
+// limit when not configured
+const DefaultQueueSize = 1024
+
+// Errors returned when a connection exceeds a limit
+var (
+	ErrRateLimited       = errors.New("connection rate limit exceeded")
+	ErrPrefixRateLimited = errors.New("prefix connection rate limit exceeded")
+	ErrSourceLimit       = errors.New("too many concurrent connections from source")
+	ErrGlobalLimit       = errors.New("too many concurrent connections")
+	ErrTooManySources    = errors.New("too many sources with active connections")
+	ErrQueueFull         = errors.New("too many connections waiting")
+)
+
+// Config holds connection limits. Zero values disable the respective limit.
+type Config struct {
+	// Rate and Burst limit new connections per source IP
+	Rate  float64
+	Burst int
+
+	// PrefixRate and PrefixBurst limit new connections per source prefix,
+	// grouping IPv4 clients by IPv4PrefixLen and IPv6 clients by IPv6PrefixLen
+	PrefixRate    float64
+	PrefixBurst   int
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// MaxPerSource caps concurrent connections per source IP
+	MaxPerSource int
+
+	// MaxConnections caps concurrent connections on the listener
+	MaxConnections int
+
+	// QueueTimeout makes connections over a limit wait up to this long for
+	// capacity instead of being rejected immediately
+	QueueTimeout time.Duration
+
+	// QueueSize caps how many connections wait for per-source limits at
+	// once; further connections over a limit are rejected
+	QueueSize int
+
+	// TableSize bounds how many sources are remembered per table. Sources
+	// with active connections are never evicted, so a new source is
+	// rejected while the table is full of them.
+	TableSize int
+}
+
+// Stats counts connections rejected per limit
+type Stats struct {
+	Allowed           uint64
+	RateLimited       uint64
+	PrefixRateLimited uint64
+	SourceLimited     uint64
+	GlobalLimited     uint64
+	TableFull         uint64
+	QueueFull         uint64
+	TrackedSources    int
+	ActiveConnections int
+	Queued            int
+}
+
+// Limiter enforces per-source and per-listener connection limits. The
+// listener-wide limit is taken with Admit before a connection is handed
+// off, so connections over it don't each hold a goroutine; the per-source
+// limits are taken with Acquire.
+type Limiter struct {
+	cfg Config
+
+	// slots holds a token per admitted connection when MaxConnections is
+	// set; blocked senders are woken in arrival order as tokens are freed
+	slots chan struct{}
+
+	mu       sync.Mutex
+	sources  *table
+	prefixes *table
+	active   int
+	waiters  *list.List
+
+	// retry dispatches the waiters again when a rate limit they wait for
+	// allows connections
+	retry *time.Timer
+
+	allowed           atomic.Uint64
+	rateLimited       atomic.Uint64
+	prefixRateLimited atomic.Uint64
+	sourceLimited     atomic.Uint64
+	globalLimited     atomic.Uint64
+	tableFull         atomic.Uint64
+	queueFull         atomic.Uint64
+}
+
+// waiter is an Acquire call queued for a per-source limit. It is woken by
+// closing ready once admitted; until then err holds the limit it waits for.
+type waiter struct {
+	ip      netip.Addr
+	elem    *list.Element
+	ready   chan struct{}
+	release func()
+	err     error
+}
+
+// NewLimiter creates a limiter enforcing cfg
+func NewLimiter(cfg Config) *Limiter {
+	if cfg.TableSize <= 0 {
+		cfg.TableSize = DefaultTableSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.IPv4PrefixLen <= 0 {
+		cfg.IPv4PrefixLen = 24
+	}
+	if cfg.IPv6PrefixLen <= 0 {
+		cfg.IPv6PrefixLen = 64
+	}
+	l := &Limiter{
+		cfg:      cfg,
+		sources:  newTable(cfg.TableSize),
+		prefixes: newTable(cfg.TableSize),
+		waiters:  list.New(),
+	}
+	if cfg.MaxConnections > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConnections)
+	}
+	return l
+}
+
+// Admit takes one of the listener's MaxConnections slots, waiting up to the
+// queue timeout for a connection to close if all are taken. On success the
+// returned function must be called once the connection closes.
+func (l *Limiter) Admit() (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.slotRelease(), nil
+	default:
+	}
+	if l.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(l.cfg.QueueTimeout)
+		defer timer.Stop()
+		select {
+		case l.slots <- struct{}{}:
+			return l.slotRelease(), nil
+		case <-timer.C:
+		}
+	}
+	l.globalLimited.Add(1)
+	return nil, ErrGlobalLimit
+}
+
+// slotRelease returns a function freeing one listener slot, once
+func (l *Limiter) slotRelease() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-l.slots })
+	}
+}
+
+// Acquire admits a new connection from addr under the per-source limits.
+// If a limit is exceeded it waits, up to the queue timeout, behind the
+// connections already waiting, and is admitted when a connection from the
+// source closes or its rate allows. On success the returned function must
+// be called once the connection closes.
+func (l *Limiter) Acquire(addr net.Addr) (func(), error) {
+	ip := netutil.AddrIP(addr)
+
+	l.mu.Lock()
+	if l.waiters.Len() == 0 {
+		release, wait, err := l.tryAcquire(ip, time.Now())
+		if err == nil {
+			l.mu.Unlock()
+			l.allowed.Add(1)
+			return release, nil
+		}
+		// Don't queue for a rate limit that can't allow the connection
+		// in time
+		if l.cfg.QueueTimeout <= 0 || wait > l.cfg.QueueTimeout {
+			l.mu.Unlock()
+			l.count(err)
+			return nil, err
+		}
+	}
+	if l.waiters.Len() >= l.cfg.QueueSize {
+		l.mu.Unlock()
+		l.queueFull.Add(1)
+		return nil, ErrQueueFull
+	}
+	w := &waiter{ip: ip, ready: make(chan struct{})}
+	w.elem = l.waiters.PushBack(w)
+	l.dispatch(time.Now())
+	l.mu.Unlock()
+
+	return l.wait(w)
+}
+
+// wait blocks until w is admitted or the queue timeout passes
+func (l *Limiter) wait(w *waiter) (func(), error) {
+	deadline := time.NewTimer(l.cfg.QueueTimeout)
+	defer deadline.Stop()
+
+	select {
+	case <-w.ready:
+	case <-deadline.C:
+		l.mu.Lock()
+		if w.elem != nil {
+			l.waiters.Remove(w.elem)
+			w.elem = nil
+			err := w.err
+			l.mu.Unlock()
+			l.count(err)
+			return nil, err
+		}
+		l.mu.Unlock()
+		// Admitted while timing out
+		<-w.ready
+	}
+	l.allowed.Add(1)
+	return w.release, nil
+}
+
+// dispatch admits the waiting connections the limits now allow, in the
+// order they arrived. A waiter held back by its own source's limit doesn't
+// hold up waiters from other sources. If any wait for a rate limit, the
+// retry timer is set for the earliest one. Callers hold l.mu.
+func (l *Limiter) dispatch(now time.Time) {
+	var retry time.Duration
+	for e := l.waiters.Front(); e != nil; {
+		next := e.Next()
+		w := e.Value.(*waiter)
+		release, wait, err := l.tryAcquire(w.ip, now)
+		if err == nil {
+			l.waiters.Remove(e)
+			w.elem = nil
+			w.release = release
+			close(w.ready)
+		} else {
+			w.err = err
+			if wait > 0 && (retry == 0 || wait < retry) {
+				retry = wait
+			}
+		}
+		e = next
+	}
+
+	if retry == 0 {
+		return
+	}
+	if l.retry == nil {
+		l.retry = time.AfterFunc(retry, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.dispatch(time.Now())
+		})
+		return
+	}
+	l.retry.Reset(retry)
+}
+
+// tryAcquire checks every per-source limit without blocking. On a rate
+// limit it returns how long until the connection could be allowed; a
+// concurrency limit returns 0 as only a closing connection frees capacity.
+// Callers hold l.mu.
+func (l *Limiter) tryAcquire(ip netip.Addr, now time.Time) (func(), time.Duration, error) {
+	source := l.sources.get(ip.String(), l.bucketFactory(l.cfg.Rate, l.cfg.Burst, now))
+	if source == nil {
+		return nil, 0, ErrTooManySources
+	}
+	if l.cfg.MaxPerSource > 0 && source.active >= l.cfg.MaxPerSource {
+		return nil, 0, ErrSourceLimit
+	}
+
+	// Check both buckets before taking from either so a rejection by one
+	// does not use up the other's tokens
+	if source.bucket != nil {
+		if wait := source.bucket.Delay(1, now); wait > 0 {
+			return nil, wait, ErrRateLimited
+		}
+	}
+	var prefix *entry
+	if l.cfg.PrefixRate > 0 {
+		prefix = l.prefixes.get(l.prefixKey(ip), l.bucketFactory(l.cfg.PrefixRate, l.cfg.PrefixBurst, now))
+		if prefix == nil {
+			return nil, 0, ErrTooManySources
+		}
+		if wait := prefix.bucket.Delay(1, now); wait > 0 {
+			return nil, wait, ErrPrefixRateLimited
+		}
+		prefix.bucket.Take(1, now)
+	}
+	if source.bucket != nil {
+		source.bucket.Take(1, now)
+	}
+
+	l.active++
+	l.sources.acquire(source)
+	if prefix != nil {
+		l.prefixes.acquire(prefix)
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			now := time.Now()
+			l.active--
+			l.sources.release(source, now)
+			if prefix != nil {
+				l.prefixes.release(prefix, now)
+			}
+			l.dispatch(now)
+		})
+	}
+	return release, 0, nil
+}
+
+// Stats returns the limiter's counters
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	tracked := l.sources.len() + l.prefixes.len()
+	active := l.active
+	queued := l.waiters.Len()
+	l.mu.Unlock()
+
+	return Stats{
+		Allowed:           l.allowed.Load(),
+		RateLimited:       l.rateLimited.Load(),
+		PrefixRateLimited: l.prefixRateLimited.Load(),
+		SourceLimited:     l.sourceLimited.Load(),
+		GlobalLimited:     l.globalLimited.Load(),
+		TableFull:         l.tableFull.Load(),
+		QueueFull:         l.queueFull.Load(),
+		TrackedSources:    tracked,
+		ActiveConnections: active,
+		Queued:            queued,
+	}
+}
+
+func (l *Limiter) count(err error) {
+	switch err {
+	case ErrRateLimited:
+		l.rateLimited.Add(1)
+	case ErrPrefixRateLimited:
+		l.prefixRateLimited.Add(1)
+	case ErrSourceLimit:
+		l.sourceLimited.Add(1)
+	case ErrGlobalLimit:
+		l.globalLimited.Add(1)
+	case ErrTooManySources:
+		l.tableFull.Add(1)
+	}
+}
+
+// bucketFactory returns a constructor for new token buckets, or nil when the
+// rate is unlimited
+func (l *Limiter) bucketFactory(rate float64, burst int, now time.Time) func() *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return func() *TokenBucket {
+		return NewTokenBucket(rate, burst, now)
+	}
+}
+
+// prefixKey returns the network prefix grouping ip
+func (l *Limiter) prefixKey(ip netip.Addr) string {
+	bits := l.cfg.IPv6PrefixLen
+	if ip.Is4() {
+		bits = l.cfg.IPv4PrefixLen
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ip.String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,274 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := NewTokenBucket(10, 2, now)
+
+	if !b.Take(1, now) || !b.Take(1, now) {
+		t.Fatal("Expected burst of 2 to be available")
+	}
+	if b.Take(1, now) {
+		t.Fatal("Expected empty bucket to refuse")
+	}
+	if wait := b.Delay(1, now); wait != 100*time.Millisecond {
+		t.Errorf("Expected 100ms until next token, got %v", wait)
+	}
+	if !b.Take(1, now.Add(100*time.Millisecond)) {
+		t.Error("Expected token after refill")
+	}
+}
+
+func TestLimiter_RatePerSource(t *testing.T) {
+	l := NewLimiter(Config{Rate: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(addr("10.0.0.1"))
+		if err != nil {
+			t.Fatalf("Connection %d rejected: %v", i, err)
+		}
+		release()
+	}
+	if _, err := l.Acquire(addr("10.0.0.1")); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+
+	// Other sources have their own bucket
+	if _, err := l.Acquire(addr("10.0.0.2")); err != nil {
+		t.Errorf("Expected other source to be allowed, got %v", err)
+	}
+
+	if stats := l.Stats(); stats.RateLimited != 1 || stats.Allowed != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiter_RatePerPrefix(t *testing.T) {
+	l := NewLimiter(Config{PrefixRate: 1, PrefixBurst: 2})
+
+	if _, err := l.Acquire(addr("192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(addr("192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(addr("192.0.2.3")); err != ErrPrefixRateLimited {
+		t.Errorf("Expected ErrPrefixRateLimited for same /24, got %v", err)
+	}
+	if _, err := l.Acquire(addr("192.0.3.1")); err != nil {
+		t.Errorf("Expected different /24 to be allowed, got %v", err)
+	}
+	if _, err := l.Acquire(addr("2001:db8:0:1::1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(addr("2001:db8:0:1::2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(addr("2001:db8:0:1::3")); err != ErrPrefixRateLimited {
+		t.Errorf("Expected ErrPrefixRateLimited for same /64, got %v", err)
+	}
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	l := NewLimiter(Config{MaxPerSource: 2})
+
+	r1, _ := l.Acquire(addr("10.0.0.1"))
+	r2, _ := l.Acquire(addr("10.0.0.1"))
+	if _, err := l.Acquire(addr("10.0.0.1")); err != ErrSourceLimit {
+		t.Errorf("Expected ErrSourceLimit, got %v", err)
+	}
+	if _, err := l.Acquire(addr("10.0.0.2")); err != nil {
+		t.Errorf("Expected other source to be allowed, got %v", err)
+	}
+
+	r1()
+	r1() // releasing twice must not free a second slot
+	if _, err := l.Acquire(addr("10.0.0.1")); err != nil {
+		t.Errorf("Expected slot to be free after release, got %v", err)
+	}
+	if _, err := l.Acquire(addr("10.0.0.1")); err != ErrSourceLimit {
+		t.Errorf("Expected ErrSourceLimit after double release, got %v", err)
+	}
+	r2()
+}
+
+func TestLimiter_Admit(t *testing.T) {
+	l := NewLimiter(Config{MaxConnections: 2})
+
+	r1, _ := l.Admit()
+	r2, _ := l.Admit()
+	if _, err := l.Admit(); err != ErrGlobalLimit {
+		t.Errorf("Expected ErrGlobalLimit, got %v", err)
+	}
+
+	r1()
+	r1() // releasing twice must not free a second slot
+	if _, err := l.Admit(); err != nil {
+		t.Errorf("Expected slot to be free after release, got %v", err)
+	}
+	if _, err := l.Admit(); err != ErrGlobalLimit {
+		t.Errorf("Expected ErrGlobalLimit after double release, got %v", err)
+	}
+	r2()
+
+	if stats := l.Stats(); stats.GlobalLimited != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiter_AdmitQueue(t *testing.T) {
+	l := NewLimiter(Config{MaxConnections: 1, QueueTimeout: time.Second})
+
+	release, err := l.Admit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, release)
+
+	start := time.Now()
+	if _, err := l.Admit(); err != nil {
+		t.Fatalf("Expected queued connection to be admitted, got %v", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("Expected connection to wait for a slot, waited %v", waited)
+	}
+}
+
+func TestLimiter_Queue(t *testing.T) {
+	l := NewLimiter(Config{MaxPerSource: 1, QueueTimeout: time.Second})
+
+	release, err := l.Acquire(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+
+	start := time.Now()
+	if _, err := l.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatalf("Expected queued connection to be admitted, got %v", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("Expected connection to wait for a slot, waited %v", waited)
+	}
+}
+
+func TestLimiter_BoundedTable(t *testing.T) {
+	l := NewLimiter(Config{Rate: 1, Burst: 5, TableSize: 100})
+
+	for i := 0; i < 10000; i++ {
+		release, err := l.Acquire(addr(fmt.Sprintf("10.%d.%d.1", i/256, i%256)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	if tracked := l.Stats().TrackedSources; tracked > 100 {
+		t.Errorf("Expected at most 100 tracked sources, got %d", tracked)
+	}
+}
+
+func TestLimiter_QueueOrder(t *testing.T) {
+	l := NewLimiter(Config{MaxPerSource: 1, QueueTimeout: time.Second})
+
+	release, err := l.Acquire(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue three connections one after another
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			release, err := l.Acquire(addr("10.0.0.1"))
+			if err != nil {
+				t.Errorf("Connection %d rejected: %v", i, err)
+				order <- -1
+				return
+			}
+			order <- i
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}()
+		for l.Stats().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	release()
+	for want := 0; want < 3; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("Expected connection %d to be admitted next, got %d", want, got)
+		}
+	}
+}
+
+func TestLimiter_QueueRate(t *testing.T) {
+	l := NewLimiter(Config{Rate: 20, Burst: 1, QueueTimeout: time.Second})
+
+	if _, err := l.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is released; the waiter is admitted once the bucket refills
+	start := time.Now()
+	if _, err := l.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatalf("Expected queued connection to be admitted, got %v", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond || waited > 500*time.Millisecond {
+		t.Errorf("Expected connection to wait about 50ms for a token, waited %v", waited)
+	}
+}
+
+func TestLimiter_QueueFull(t *testing.T) {
+	l := NewLimiter(Config{MaxPerSource: 1, QueueTimeout: time.Second, QueueSize: 1})
+
+	release, err := l.Acquire(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	go l.Acquire(addr("10.0.0.1"))
+	for l.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := l.Acquire(addr("10.0.0.2")); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if stats := l.Stats(); stats.QueueFull != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestLimiter_TableFullOfActiveSources(t *testing.T) {
+	l := NewLimiter(Config{MaxPerSource: 1, TableSize: 2})
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if _, err := l.Acquire(addr(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Active sources are never evicted to make room
+	if _, err := l.Acquire(addr("10.0.0.3")); err != ErrTooManySources {
+		t.Errorf("Expected ErrTooManySources, got %v", err)
+	}
+	stats := l.Stats()
+	if stats.TrackedSources > 2 || stats.TableFull != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
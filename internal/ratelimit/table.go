@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"container/list"
+	"time"
+)
+
+// entry tracks the state kept for one source IP or prefix
+type entry struct {
+	key    string
+	bucket *TokenBucket
+	active int
+	elem   *list.Element
+}
+
+// table is a bounded map of per-source state. Entries with active
+// connections are pinned; idle entries are kept in LRU order and evicted
+// once the table is full. A table full of pinned entries takes no new ones.
+type table struct {
+	capacity int
+	entries  map[string]*entry
+	idle     *list.List
+}
+
+func newTable(capacity int) *table {
+	return &table{
+		capacity: capacity,
+		entries:  make(map[string]*entry),
+		idle:     list.New(),
+	}
+}
+
+// get returns the entry for key, creating it if needed, or nil if the
+// table is full and no entry can be evicted
+func (t *table) get(key string, newBucket func() *TokenBucket) *entry {
+	if e, ok := t.entries[key]; ok {
+		if e.elem != nil {
+			t.idle.MoveToFront(e.elem)
+		}
+		return e
+	}
+
+	for len(t.entries) >= t.capacity && t.idle.Len() > 0 {
+		oldest := t.idle.Back()
+		t.idle.Remove(oldest)
+		delete(t.entries, oldest.Value.(*entry).key)
+	}
+	if len(t.entries) >= t.capacity {
+		return nil
+	}
+
+	e := &entry{key: key}
+	if newBucket != nil {
+		e.bucket = newBucket()
+	}
+	e.elem = t.idle.PushFront(e)
+	t.entries[key] = e
+	return e
+}
+
+// acquire marks a connection as active on the entry
+func (t *table) acquire(e *entry) {
+	e.active++
+	if e.elem != nil {
+		t.idle.Remove(e.elem)
+		e.elem = nil
+	}
+}
+
+// release marks a connection on the entry as finished
+func (t *table) release(e *entry, now time.Time) {
+	e.active--
+	if e.active > 0 {
+		return
+	}
+	if e.bucket == nil || e.bucket.Full(now) {
+		delete(t.entries, e.key)
+		return
+	}
+	e.elem = t.idle.PushFront(e)
+}
+
+func (t *table) len() int {
+	return len(t.entries)
+}
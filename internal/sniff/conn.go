@@ -0,0 +1,37 @@
+package sniff
+
+import (
+	"bytes"
+	"io"
+	"net"
+)
+
+// peekedConn replays bytes consumed while sniffing before reading from the
+// underlying connection
+type peekedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func wrap(conn net.Conn, peeked []byte) net.Conn {
+	if len(peeked) == 0 {
+		return conn
+	}
+	return &peekedConn{
+		Conn:   conn,
+		reader: io.MultiReader(bytes.NewReader(peeked), conn),
+	}
+}
+
+// Read reads the peeked bytes first and then from the connection
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite half-closes the underlying connection when supported
+func (c *peekedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,201 @@
+package sniff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Protocol identifies a protocol recognised from the first bytes of a connection
+type Protocol string
+
+// Built-in protocols
+const (
+	ProtocolUnknown Protocol = ""
+	ProtocolTLS     Protocol = "tls"
+	ProtocolSSH     Protocol = "ssh"
+	ProtocolHTTP    Protocol = "http"
+)
+
+// Sniffing limits
+const (
+	// DefaultPeekSize is the maximum number of bytes inspected per connection
+	DefaultPeekSize = 64
+	// DefaultPeekTimeout is used when no peek timeout is configured
+	DefaultPeekTimeout = 2 * time.Second
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+	[]byte("DELETE "), []byte("OPTIONS "), []byte("PATCH "),
+	[]byte("CONNECT "), []byte("TRACE "),
+}
+
+var sshPrefix = []byte("SSH-2.0")
+
+// Rule routes connections matching a protocol, prefix or regular expression
+// to a backend pool. Exactly one of Protocol, Prefix and Regexp should be set.
+type Rule struct {
+	Protocol Protocol
+	Prefix   []byte
+	Regexp   *regexp.Regexp
+	Pool     string
+}
+
+// Sniffer classifies new connections by peeking at their first bytes
+type Sniffer struct {
+	rules       []Rule
+	defaultPool string
+	peekTimeout time.Duration
+	peekSize    int
+}
+
+// NewSniffer creates a new sniffer. Connections that match no rule, or send
+// nothing within peekTimeout, are routed to defaultPool.
+func NewSniffer(rules []Rule, defaultPool string, peekTimeout time.Duration) *Sniffer {
+	if peekTimeout <= 0 {
+		peekTimeout = DefaultPeekTimeout
+	}
+	return &Sniffer{
+		rules:       rules,
+		defaultPool: defaultPool,
+		peekTimeout: peekTimeout,
+		peekSize:    DefaultPeekSize,
+	}
+}
+
+// Route peeks at the connection and returns the pool it should be sent to,
+// together with a connection that replays the peeked bytes
+func (s *Sniffer) Route(conn net.Conn) (string, net.Conn, error) {
+	buf := make([]byte, 0, s.peekSize)
+	deadline := time.Now().Add(s.peekTimeout)
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+
+	for len(buf) < s.peekSize {
+		n, err := conn.Read(buf[len(buf):s.peekSize])
+		buf = buf[:len(buf)+n]
+
+		if pool, ok := s.match(buf); ok {
+			return pool, wrap(conn, buf), nil
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err == io.EOF {
+				break
+			}
+			return "", nil, err
+		}
+		if !s.mayMatch(buf) {
+			break
+		}
+	}
+
+	return s.defaultPool, wrap(conn, buf), nil
+}
+
+// match returns the pool of the first rule matching data
+func (s *Sniffer) match(data []byte) (string, bool) {
+	if len(data) == 0 {
+		return "", false
+	}
+	detected := Detect(data)
+	for _, rule := range s.rules {
+		switch {
+		case rule.Protocol != ProtocolUnknown:
+			if rule.Protocol == detected {
+				return rule.Pool, true
+			}
+		case rule.Prefix != nil:
+			if bytes.HasPrefix(data, rule.Prefix) {
+				return rule.Pool, true
+			}
+		case rule.Regexp != nil:
+			if rule.Regexp.Match(data) {
+				return rule.Pool, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mayMatch reports whether reading more bytes could still make a rule match.
+// Regular expressions are only evaluated against the bytes already read.
+func (s *Sniffer) mayMatch(data []byte) bool {
+	for _, rule := range s.rules {
+		switch {
+		case rule.Protocol != ProtocolUnknown:
+			if mayBeProtocol(rule.Protocol, data) {
+				return true
+			}
+		case rule.Prefix != nil:
+			if len(data) < len(rule.Prefix) && bytes.HasPrefix(rule.Prefix, data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Detect classifies data as one of the built-in protocols
+func Detect(data []byte) Protocol {
+	switch {
+	case isTLSRecord(data):
+		return ProtocolTLS
+	case bytes.HasPrefix(data, sshPrefix):
+		return ProtocolSSH
+	case isHTTPRequest(data):
+		return ProtocolHTTP
+	default:
+		return ProtocolUnknown
+	}
+}
+
+// isTLSRecord checks for a TLS handshake record header (type 0x16, major version 3)
+func isTLSRecord(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0x16 && data[1] == 0x03 && data[2] <= 0x04
+}
+
+func isHTTPRequest(data []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(data, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayBeProtocol reports whether data is a prefix of something that would be
+// detected as protocol once more bytes arrive
+func mayBeProtocol(protocol Protocol, data []byte) bool {
+	switch protocol {
+	case ProtocolTLS:
+		return len(data) < 3 && (len(data) < 1 || data[0] == 0x16) && (len(data) < 2 || data[1] == 0x03)
+	case ProtocolSSH:
+		return len(data) < len(sshPrefix) && bytes.HasPrefix(sshPrefix, data)
+	case ProtocolHTTP:
+		for _, method := range httpMethods {
+			if len(data) < len(method) && bytes.HasPrefix(method, data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseProtocol validates a protocol name from configuration
+func ParseProtocol(name string) (Protocol, error) {
+	switch p := Protocol(name); p {
+	case ProtocolTLS, ProtocolSSH, ProtocolHTTP:
+		return p, nil
+	default:
+		return ProtocolUnknown, fmt.Errorf("unknown protocol %q", name)
+	}
+}
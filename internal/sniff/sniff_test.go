@@ -0,0 +1,97 @@
+package sniff
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Protocol
+	}{
+		{"tls client hello", []byte{0x16, 0x03, 0x01, 0x02, 0x00}, ProtocolTLS},
+		{"ssh banner", []byte("SSH-2.0-OpenSSH_9.6\r\n"), ProtocolSSH},
+		{"http get", []byte("GET / HTTP/1.1\r\n"), ProtocolHTTP},
+		{"http options", []byte("OPTIONS * HTTP/1.1\r\n"), ProtocolHTTP},
+		{"lowercase method", []byte("get / HTTP/1.1\r\n"), ProtocolUnknown},
+		{"redis", []byte("*1\r\n$4\r\nPING\r\n"), ProtocolUnknown},
+		{"empty", nil, ProtocolUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.data); got != tt.expected {
+				t.Errorf("Detect(%q) = %q, expected %q", tt.data, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSniffer_Route(t *testing.T) {
+	sniffer := NewSniffer([]Rule{
+		{Protocol: ProtocolTLS, Pool: "tls"},
+		{Protocol: ProtocolSSH, Pool: "ssh"},
+		{Protocol: ProtocolHTTP, Pool: "http"},
+		{Prefix: []byte("PING"), Pool: "ping"},
+		{Regexp: regexp.MustCompile(`^\*\d+\r\n`), Pool: "redis"},
+	}, "default", 100*time.Millisecond)
+
+	tests := []struct {
+		name     string
+		writes   []string
+		expected string
+	}{
+		{"http", []string{"GET / HTTP/1.1\r\n\r\n"}, "http"},
+		{"ssh split across writes", []string{"SS", "H-2.0-client\r\n"}, "ssh"},
+		{"tls", []string{"\x16\x03\x01\x00\x05hello"}, "tls"},
+		{"prefix", []string{"PING\r\n"}, "ping"},
+		{"regex", []string{"*1\r\n$4\r\nPING\r\n"}, "redis"},
+		{"unmatched", []string{"hello"}, "default"},
+		{"server speaks first", nil, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			go func() {
+				for _, w := range tt.writes {
+					client.Write([]byte(w))
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+
+			pool, conn, err := sniffer.Route(server)
+			if err != nil {
+				t.Fatalf("Route failed: %v", err)
+			}
+			if pool != tt.expected {
+				t.Errorf("Expected pool %q, got %q", tt.expected, pool)
+			}
+
+			// The peeked bytes must still be readable by the proxy
+			expected := ""
+			for _, w := range tt.writes {
+				expected += w
+			}
+			if expected == "" {
+				return
+			}
+			got := make([]byte, len(expected))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatalf("Failed to read replayed bytes: %v", err)
+			}
+			if string(got) != expected {
+				t.Errorf("Expected replayed %q, got %q", expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package tracing
+
+import (
+	"l4-load-balancer/internal/metrics"
+)
+
+var (
+	spansExported = metrics.NewCounterVec(
+		"lb_tracing_spans_exported_total",
+		"Trace spans exported.")
+	spansDropped = metrics.NewCounterVec(
+		"lb_tracing_spans_dropped_total",
+		"Trace spans dropped because the export queue was full or the export failed, by reason.",
+		"reason")
+)
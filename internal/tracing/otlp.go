@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultOTLPTimeout bounds each export request, retries included
+const DefaultOTLPTimeout = 10 * time.Second
+
+// otlpTracesPath is appended to OTLP endpoints given without a path
+const otlpTracesPath = "/v1/traces"
+
+// NewOTLPExporter creates an exporter sending gzipped OTLP/protobuf over
+// HTTP to endpoint, e.g. http://collector:4318. Endpoints without a path
+// get /v1/traces, and headers are added to every request. Failed exports
+// are retried with exponential backoff until the export timeout.
+func NewOTLPExporter(ctx context.Context, endpoint string, headers map[string]string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected an http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithCompression(otlptracehttp.GzipCompression),
+		otlptracehttp.WithTimeout(DefaultOTLPTimeout),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{
+			Enabled:         true,
+			InitialInterval: time.Second,
+			MaxInterval:     5 * time.Second,
+			MaxElapsedTime:  DefaultOTLPTimeout,
+		}))
+}
@@ -0,0 +1,216 @@
+// Package tracing records OpenTelemetry spans for the lifecycle of proxied
+// connections and exports them in batches. All Span methods are safe to call
+// on a nil span, which is what an unsampled connection or a missing tracer
+// gets.
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer defaults
+const (
+	DefaultServiceName   = "l4-load-balancer"
+	DefaultQueueSize     = 4096
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// SpanKind is the role of a span
+type SpanKind = trace.SpanKind
+
+// Span kinds
+const (
+	KindInternal = trace.SpanKindInternal
+	KindServer   = trace.SpanKindServer
+	KindClient   = trace.SpanKindClient
+)
+
+// Attribute is a key/value pair attached to a span
+type Attribute = attribute.KeyValue
+
+// String returns a string attribute
+func String(key, value string) Attribute { return attribute.String(key, value) }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return attribute.Int(key, value) }
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attribute { return attribute.Int64(key, value) }
+
+// Float64 returns a floating point attribute
+func Float64(key string, value float64) Attribute { return attribute.Float64(key, value) }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return attribute.Bool(key, value) }
+
+// Options configures a Tracer. Zero values use the defaults; SamplePercent
+// defaults to 100.
+type Options struct {
+	ServiceName   string
+	SamplePercent float64
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Tracer creates spans and exports them in batches from a background
+// goroutine. Spans that end while QueueSize spans are waiting for export are
+// dropped and counted in lb_tracing_spans_dropped_total.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewTracer creates a tracer exporting to exporter
+func NewTracer(exporter sdktrace.SpanExporter, opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultServiceName
+	}
+	if opts.SamplePercent <= 0 {
+		opts.SamplePercent = 100
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	limit := &queueLimit{max: int64(opts.QueueSize)}
+	batcher := sdktrace.NewBatchSpanProcessor(&countingExporter{SpanExporter: exporter, limit: limit},
+		sdktrace.WithMaxQueueSize(opts.QueueSize),
+		sdktrace.WithMaxExportBatchSize(opts.BatchSize),
+		sdktrace.WithBatchTimeout(opts.FlushInterval))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(min(opts.SamplePercent, 100)/100))),
+		sdktrace.WithSpanProcessor(&limitedProcessor{SpanProcessor: batcher, limit: limit}))
+	return &Tracer{
+		provider: provider,
+		tracer:   provider.Tracer(DefaultServiceName),
+	}
+}
+
+// Start begins a root span, or returns nil if the tracer is nil or the
+// trace isn't sampled
+func (t *Tracer) Start(name string, kind SpanKind, attrs ...Attribute) *Span {
+	if t == nil {
+		return nil
+	}
+	ctx, span := t.tracer.Start(context.Background(), name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	if !span.IsRecording() {
+		return nil
+	}
+	return &Span{ctx: ctx, span: span, tracer: t.tracer}
+}
+
+// ForceFlush exports the queued spans, giving up when ctx is done
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	return t.provider.ForceFlush(ctx)
+}
+
+// Shutdown exports the queued spans and shuts the exporter down, giving up
+// when ctx is done. Spans ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// Span is an operation in progress. A nil span ignores every call.
+type Span struct {
+	ctx    context.Context
+	span   trace.Span
+	tracer trace.Tracer
+}
+
+// Child begins a span nested in s
+func (s *Span) Child(name string, kind SpanKind, attrs ...Attribute) *Span {
+	if s == nil {
+		return nil
+	}
+	ctx, span := s.tracer.Start(s.ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	return &Span{ctx: ctx, span: span, tracer: s.tracer}
+}
+
+// SetAttributes adds attributes to the span, replacing any with the same
+// key
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// SetError marks the span as failed with err's message. A nil err is
+// ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End finishes the span and queues it for export. Calls after the first
+// are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// queueLimit counts the spans handed to the batcher that haven't been
+// exported yet, so spans over the queue size are dropped where they can be
+// counted rather than inside the batcher
+type queueLimit struct {
+	max     int64
+	pending atomic.Int64
+}
+
+// limitedProcessor drops ended spans while the queue is full
+type limitedProcessor struct {
+	sdktrace.SpanProcessor
+	limit *queueLimit
+}
+
+func (p *limitedProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		return
+	}
+	if p.limit.pending.Add(1) > p.limit.max {
+		p.limit.pending.Add(-1)
+		spansDropped.With("queue_full").Inc()
+		return
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// countingExporter frees queue capacity as spans are exported and counts
+// the spans of failed exports as dropped. The OTLP exporter has already
+// retried by the time it returns an error.
+type countingExporter struct {
+	sdktrace.SpanExporter
+	limit *queueLimit
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	e.limit.pending.Add(-int64(len(spans)))
+	if err != nil {
+		spansDropped.With("export_failed").Add(float64(len(spans)))
+	} else {
+		spansExported.With().Add(float64(len(spans)))
+	}
+	return err
+}
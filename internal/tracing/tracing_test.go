@@ -0,0 +1,233 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracer_Spans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer(exporter, Options{})
+
+	root := tracer.Start("connection", KindServer, String("client.address", "192.0.2.1:1234"))
+	child := root.Child("dial", KindClient)
+	child.SetError(errors.New("connection refused"))
+	child.End()
+	root.SetAttributes(Int64("bytes_in", 5), Int64("bytes_in", 7))
+	root.End()
+	root.End()
+
+	// Shutting down would clear the in-memory exporter
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	dial, conn := spans[0], spans[1]
+	if dial.SpanContext.TraceID() != conn.SpanContext.TraceID() || dial.Parent.SpanID() != conn.SpanContext.SpanID() || conn.Parent.IsValid() {
+		t.Errorf("Expected dial to be a child of connection: %+v %+v", dial, conn)
+	}
+	if dial.Status.Code != codes.Error || dial.Status.Description != "connection refused" {
+		t.Errorf("Expected dial to have failed, got %+v", dial.Status)
+	}
+	if len(conn.Attributes) != 2 || conn.Attributes[1].Value.AsInt64() != 7 {
+		t.Errorf("Expected bytes_in to be replaced, got %+v", conn.Attributes)
+	}
+	if conn.EndTime.Before(conn.StartTime) {
+		t.Error("Expected the span to end after it started")
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	tracer := NewTracer(tracetest.NewInMemoryExporter(), Options{SamplePercent: 1e-9})
+	defer tracer.Shutdown(context.Background())
+	span := tracer.Start("connection", KindServer)
+	if span != nil {
+		t.Fatal("Expected no span when nothing is sampled")
+	}
+
+	// Every method is a no-op on the nil span
+	span.Child("dial", KindClient).End()
+	span.SetAttributes(Bool("ok", true))
+	span.SetError(errors.New("ignored"))
+	span.End()
+
+	var nilTracer *Tracer
+	if nilTracer.Start("connection", KindServer) != nil {
+		t.Error("Expected a nil tracer to return nil spans")
+	}
+}
+
+// blockingExporter holds every export until release is closed
+type blockingExporter struct {
+	release chan struct{}
+}
+
+func (e blockingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	select {
+	case <-e.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e blockingExporter) Shutdown(ctx context.Context) error { return nil }
+
+func TestTracer_DropsWhenQueueFull(t *testing.T) {
+	exporter := blockingExporter{release: make(chan struct{})}
+	tracer := NewTracer(exporter, Options{QueueSize: 1, BatchSize: 1, FlushInterval: time.Millisecond})
+	dropped := spansDropped.With("queue_full").Value()
+
+	for i := 0; i < 3; i++ {
+		tracer.Start("connection", KindServer).End()
+	}
+	if got := spansDropped.With("queue_full").Value() - dropped; got != 2 {
+		t.Errorf("Expected 2 spans dropped, got %v", got)
+	}
+
+	close(exporter.release)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTracer_ShutdownTimeout(t *testing.T) {
+	exporter := blockingExporter{release: make(chan struct{})}
+	defer close(exporter.release)
+	tracer := NewTracer(exporter, Options{})
+	tracer.Start("connection", KindServer).End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := tracer.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected shutdown to time out, got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Expected shutdown to give up after the timeout, took %v", waited)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter, err := NewWriterExporter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewTracer(exporter, Options{})
+	root := tracer.Start("connection", KindServer, Int("retries", 1))
+	root.Child("select_backend", KindInternal, String("algorithm", "round_robin")).End()
+	root.End()
+	tracer.Shutdown(context.Background())
+
+	type line struct {
+		Name        string
+		SpanContext struct{ SpanID string }
+		Parent      struct{ SpanID string }
+	}
+	var lines []line
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	if lines[0].Name != "select_backend" || lines[0].Parent.SpanID != lines[1].SpanContext.SpanID {
+		t.Errorf("Unexpected child span %+v", lines[0])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		path     string
+		auth     string
+		encoding string
+		body     collectorpb.ExportTraceServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// The first export is refused and retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		path, auth, encoding = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := io.ReadAll(gz)
+		if err := proto.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+
+	if _, err := NewOTLPExporter(context.Background(), "collector:4318", nil); err == nil {
+		t.Error("Expected an error for an endpoint without a scheme")
+	}
+	exporter, err := NewOTLPExporter(context.Background(), collector.URL, map[string]string{"Authorization": "Bearer token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := NewTracer(exporter, Options{ServiceName: "edge"})
+	root := tracer.Start("connection", KindServer, Int64("bytes_out", 42))
+	root.SetError(errors.New("dial failed"))
+	root.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || path != "/v1/traces" || auth != "Bearer token" || encoding != "gzip" {
+		t.Errorf("Unexpected %d requests, last to %s with Authorization %q and Content-Encoding %q", requests, path, auth, encoding)
+	}
+	if len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected request body %+v", &body)
+	}
+	rs := body.ResourceSpans[0]
+	service := ""
+	for _, attr := range rs.Resource.Attributes {
+		if attr.Key == "service.name" {
+			service = attr.Value.GetStringValue()
+		}
+	}
+	if service != "edge" {
+		t.Errorf("Expected service name edge, got %q", service)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if span.Name != "connection" || span.Attributes[0].Value.GetIntValue() != 42 || span.Status.Message != "dial failed" {
+		t.Errorf("Unexpected span %+v", span)
+	}
+}
@@ -0,0 +1,31 @@
+package dialer
+
+import (
+	"os"
+	"syscall"
+)
+
+// socketControl returns a control function that binds sockets to iface and
+// sets their mark
+func socketControl(iface string, mark int) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if iface != "" {
+				if err := syscall.BindToDevice(int(fd), iface); err != nil {
+					sockErr = os.NewSyscallError("setsockopt SO_BINDTODEVICE", err)
+					return
+				}
+			}
+			if mark != 0 {
+				if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+					sockErr = os.NewSyscallError("setsockopt SO_MARK", err)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dialer
+
+import (
+	"errors"
+	"syscall"
+)
+
+// socketControl is not available on this platform
+func socketControl(iface string, mark int) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("binding to an interface and socket marks are only supported on Linux")
+}
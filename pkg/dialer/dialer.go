@@ -0,0 +1,128 @@
+// Package dialer opens connections to backends over TCP or Unix domain
+// sockets. A backend address selects the transport by scheme:
+// "unix:///run/app.sock" is a Unix domain socket, while "tcp://host:port"
+// and a bare "host:port" are TCP.
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Address schemes
+const (
+	SchemeTCP  = "tcp://"
+	SchemeUnix = "unix://"
+)
+
+// Dialer opens connections; *net.Dialer implements it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Default is used when no dialer is given
+var Default Dialer = &net.Dialer{}
+
+// Options configure the sockets opened by a dialer from New. They apply
+// to TCP connections only.
+type Options struct {
+	// SourceAddress is the local IP connections are made from
+	SourceAddress string
+
+	// Interface binds connections to a network interface (Linux only)
+	Interface string
+
+	// Mark sets SO_MARK on connections for policy routing (Linux only)
+	Mark int
+}
+
+// New creates a dialer that binds and marks the TCP sockets it opens
+func New(opts Options) (Dialer, error) {
+	d := &netDialer{}
+	if opts.SourceAddress != "" {
+		ip, err := netip.ParseAddr(opts.SourceAddress)
+		if err != nil {
+			return nil, fmt.Errorf("source address %q: %w", opts.SourceAddress, err)
+		}
+		d.tcp.LocalAddr = &net.TCPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}
+	}
+	if opts.Interface != "" || opts.Mark != 0 {
+		control, err := socketControl(opts.Interface, opts.Mark)
+		if err != nil {
+			return nil, err
+		}
+		d.tcp.Control = control
+	}
+	return d, nil
+}
+
+// netDialer applies its options to TCP connections and dials other
+// networks plainly
+type netDialer struct {
+	tcp   net.Dialer
+	other net.Dialer
+}
+
+func (d *netDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if strings.HasPrefix(network, "tcp") {
+		return d.tcp.DialContext(ctx, network, address)
+	}
+	return d.other.DialContext(ctx, network, address)
+}
+
+// Split returns the network and the address to dial for a backend address
+func Split(address string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, SchemeUnix):
+		path := strings.TrimPrefix(address, SchemeUnix)
+		if path == "" {
+			return "", "", fmt.Errorf("unix address %q has no socket path", address)
+		}
+		return "unix", path, nil
+	case strings.HasPrefix(address, SchemeTCP):
+		return "tcp", strings.TrimPrefix(address, SchemeTCP), nil
+	case strings.Contains(address, "://"):
+		return "", "", fmt.Errorf("unsupported scheme in address %q", address)
+	}
+	return "tcp", address, nil
+}
+
+// Dial connects to a backend address with d, or Default if d is nil
+func Dial(ctx context.Context, d Dialer, address string) (net.Conn, error) {
+	network, addr, err := Split(address)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		d = Default
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// DialTLS connects to a backend address and completes a TLS handshake. If
+// config has no ServerName, the host of a TCP address is verified.
+func DialTLS(ctx context.Context, d Dialer, address string, config *tls.Config) (net.Conn, error) {
+	conn, err := Dial(ctx, d, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServerName == "" {
+		if network, addr, _ := Split(address); network == "tcp" {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				config = config.Clone()
+				config.ServerName = host
+			}
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,130 @@
+package dialer
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+		wantErr bool
+	}{
+		{address: "10.0.0.1:8080", network: "tcp", addr: "10.0.0.1:8080"},
+		{address: "tcp://10.0.0.1:8080", network: "tcp", addr: "10.0.0.1:8080"},
+		{address: "unix:///run/app.sock", network: "unix", addr: "/run/app.sock"},
+		{address: "unix://", wantErr: true},
+		{address: "udp://10.0.0.1:53", wantErr: true},
+	}
+	for _, tt := range tests {
+		network, addr, err := Split(tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Split(%q) succeeded, want error", tt.address)
+			}
+			continue
+		}
+		if err != nil || network != tt.network || addr != tt.addr {
+			t.Errorf("Split(%q) = %q, %q, %v, want %q, %q", tt.address, network, addr, err, tt.network, tt.addr)
+		}
+	}
+}
+
+// echo serves ln, echoing back whatever each connection sends
+func echo(t *testing.T, ln net.Listener) {
+	t.Helper()
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+// roundTrip checks that conn echoes what is written to it
+func roundTrip(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("read %q, want %q", buf, "ping")
+	}
+}
+
+func TestDial_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backend.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	echo(t, ln)
+
+	conn, err := Dial(context.Background(), nil, SchemeUnix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn)
+}
+
+func TestNew_SourceAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, ln)
+
+	d, err := New(Options{SourceAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Dial(context.Background(), d, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("connection made from %s, want 127.0.0.1", ip)
+	}
+	roundTrip(t, conn)
+
+	if _, err := New(Options{SourceAddress: "not-an-ip"}); err == nil {
+		t.Error("New accepted an invalid source address")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	p := NewPipe()
+	echo(t, p)
+
+	conn, err := Dial(context.Background(), p, "anything:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn)
+
+	p.Close()
+	if _, err := Dial(context.Background(), p, "anything:1"); err == nil {
+		t.Error("dial succeeded on a closed pipe")
+	}
+}
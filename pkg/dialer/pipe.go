@@ -0,0 +1,66 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Pipe is an in-memory Dialer and net.Listener pair for tests. Each dial
+// returns one end of a net.Pipe whose other end is returned by Accept,
+// whatever the address dialed. Both fail with net.ErrClosed once the pipe
+// is closed.
+type Pipe struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPipe creates an in-memory dialer and listener
+func NewPipe() *Pipe {
+	return &Pipe{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// DialContext connects to the pipe's listener, waiting for it to accept
+func (p *Pipe) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case p.conns <- server:
+		return client, nil
+	case <-p.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for the next dial
+func (p *Pipe) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case <-p.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting; dials fail from then on
+func (p *Pipe) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+// Addr returns a placeholder address
+func (p *Pipe) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
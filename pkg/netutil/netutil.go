@@ -0,0 +1,25 @@
+// Package netutil holds small helpers for working with network addresses
+package netutil
+
+import (
+	"net"
+	"net/netip"
+)
+
+// AddrIP extracts the IP from a connection's address, unmapping IPv4-mapped
+// IPv6 addresses. It returns the zero Addr if addr has no IP, such as a Unix
+// socket address.
+func AddrIP(addr net.Addr) netip.Addr {
+	if addr == nil {
+		return netip.Addr{}
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(tcp.IP); ok {
+			return ip.Unmap()
+		}
+	}
+	if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+		return ap.Addr().Unmap()
+	}
+	return netip.Addr{}
+}
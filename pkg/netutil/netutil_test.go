@@ -0,0 +1,25 @@
+package netutil
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestAddrIP(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want netip.Addr
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, netip.MustParseAddr("192.0.2.1")},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 80}, netip.MustParseAddr("192.0.2.1")},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}, netip.MustParseAddr("2001:db8::1")},
+		{&net.UnixAddr{Name: "/run/app.sock", Net: "unix"}, netip.Addr{}},
+		{nil, netip.Addr{}},
+	}
+	for _, tt := range tests {
+		if got := AddrIP(tt.addr); got != tt.want {
+			t.Errorf("AddrIP(%v) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
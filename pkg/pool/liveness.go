@@ -0,0 +1,68 @@
+package pool
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// probeTimeout bounds the fallback liveness read on connections that can't
+// be peeked at
+const probeTimeout = time.Millisecond
+
+// checkAlive reports whether an idle connection is still usable without
+// losing any data the backend has sent on it. The returned connection must
+// be used in place of conn.
+func checkAlive(conn net.Conn) (net.Conn, bool) {
+	if rc, ok := conn.(*replayConn); ok && len(rc.pending) > 0 {
+		return conn, true
+	}
+	if alive, ok := peekAlive(conn); ok {
+		return conn, alive
+	}
+	return readAlive(conn)
+}
+
+// readAlive probes conn with a short read. A byte that arrives is kept and
+// replayed by the returned connection; a timeout means the connection is
+// idle but open.
+func readAlive(conn net.Conn) (net.Conn, bool) {
+	conn.SetReadDeadline(time.Now().Add(probeTimeout))
+	one := make([]byte, 1)
+	n, err := conn.Read(one)
+	conn.SetReadDeadline(time.Time{})
+
+	if n > 0 {
+		if rc, ok := conn.(*replayConn); ok {
+			rc.pending = append(rc.pending, one[:n]...)
+			return rc, true
+		}
+		return &replayConn{Conn: conn, pending: one[:n]}, true
+	}
+	return conn, errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// replayConn returns bytes read by a liveness probe before reading from the
+// underlying connection
+type replayConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// CloseWrite half-closes the underlying connection if it supports it
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package pool
+
+import "net"
+
+// peekAlive is not available on this platform; the caller falls back to a
+// read probe
+func peekAlive(conn net.Conn) (alive, ok bool) {
+	return false, false
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package pool
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// peekAlive checks a socket for EOF with MSG_PEEK, leaving any pending data
+// in the socket buffer. ok is false if conn exposes no socket to peek at.
+// Go keeps its sockets in non-blocking mode, so the peek never waits.
+func peekAlive(conn net.Conn) (alive, ok bool) {
+	sc, isSocket := conn.(syscall.Conn)
+	if !isSocket {
+		return false, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false, false
+	}
+
+	var n int
+	var peekErr error
+	buf := make([]byte, 1)
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, peekErr = syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+		return true
+	})
+	if err != nil {
+		return false, true
+	}
+
+	switch {
+	case errors.Is(peekErr, syscall.EAGAIN), errors.Is(peekErr, syscall.EWOULDBLOCK), errors.Is(peekErr, syscall.EINTR):
+		// Nothing to read yet: open and idle
+		return true, true
+	case peekErr != nil:
+		return false, true
+	}
+	// Zero bytes is EOF; pending data means the connection is still open
+	return n > 0, true
+}
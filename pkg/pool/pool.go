@@ -1,107 +1,607 @@
 package pool
 
 import (
+	"container/list"
+	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"l4-load-balancer/pkg/dialer"
+)
+
+// DefaultDialTimeout bounds connecting to the backend unless overridden
+const DefaultDialTimeout = 5 * time.Second
+
+// Reaper intervals. The reaper runs at half the shortest idle time or
+// lifetime so connections outlive their limit by at most that much.
+const (
+	DefaultReapInterval = 5 * time.Second
+	minReapInterval     = 10 * time.Millisecond
 )
 
-// ConnectionPool manages a pool of connections to backend servers
+// ConnectionPool manages a pool of connections to backend servers. Idle
+// connections are closed after the max idle time and any connection after
+// the max lifetime; a background reaper enforces both and, once Warm has
+// been called, keeps the minimum number of idle connections open. Callers
+// of GetContext wait in a FIFO queue when the pool is at its maximum size.
 type ConnectionPool struct {
 	address     string
 	maxSize     int
-	connections chan net.Conn
+	dialer      dialer.Dialer
+	dialTimeout time.Duration
+
 	mu          sync.RWMutex
-	active      int
+	maxIdleTime time.Duration
+	maxLifetime time.Duration
+	minIdle     int
+	idle        []idleConn
+	created     map[net.Conn]time.Time
+	warm        bool
+	closed      bool
+
+	// waiters queues the GetContext calls waiting for a connection or a
+	// free slot, oldest first
+	waiters *list.List
+
+	// active counts the open connections the pool is responsible for,
+	// both idle and handed out, plus those being dialed
+	active int
+
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+	dialErrors atomic.Uint64
+
+	waits        atomic.Uint64
+	waitTimeouts atomic.Uint64
+	waitTime     atomic.Int64
+
+	reaperOnce sync.Once
+	refill     chan struct{}
+	done       chan struct{}
+}
+
+// idleConn is a connection waiting in the pool
+type idleConn struct {
+	conn    net.Conn
+	created time.Time
+	since   time.Time
+}
+
+// waiter is a GetContext call waiting in the queue. It is granted either a
+// returned connection or, with conn nil, a reserved slot to dial with.
+type waiter struct {
+	elem  *list.Element
+	ready chan struct{}
+	conn  net.Conn
+	err   error
 }
 
-// NewConnectionPool creates a new connection pool
+// Stats describes a pool's usage since it was created
+type Stats struct {
+	// Hits counts Gets served by an idle connection and Misses those that
+	// had to dial
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+
+	// Evictions counts idle connections closed for exceeding the idle time
+	// or lifetime or because the backend closed them
+	Evictions  uint64 `json:"evictions"`
+	DialErrors uint64 `json:"dial_errors"`
+
+	// Waits counts GetContext calls that had to queue, WaitTimeouts those
+	// whose context ended first, and WaitTime is their total time queued
+	Waits        uint64        `json:"waits"`
+	WaitTimeouts uint64        `json:"wait_timeouts"`
+	WaitTime     time.Duration `json:"wait_time"`
+
+	Active  int `json:"active"`
+	Idle    int `json:"idle"`
+	Waiting int `json:"waiting"`
+}
+
+// NewConnectionPool creates a new connection pool. The address may have a
+// unix:// or tcp:// scheme.
 func NewConnectionPool(address string, maxSize int) *ConnectionPool {
 	return &ConnectionPool{
 		address:     address,
 		maxSize:     maxSize,
-		connections: make(chan net.Conn, maxSize),
+		dialer:      dialer.Default,
+		dialTimeout: DefaultDialTimeout,
+		created:     make(map[net.Conn]time.Time),
+		waiters:     list.New(),
+		refill:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
 	}
 }
 
-// Get retrieves a connection from the pool or creates a new one
+// SetDialer sets the dialer used to open connections
+func (p *ConnectionPool) SetDialer(d dialer.Dialer) {
+	p.dialer = d
+}
+
+// SetDialTimeout sets how long creating a new connection may take
+func (p *ConnectionPool) SetDialTimeout(timeout time.Duration) {
+	p.dialTimeout = timeout
+}
+
+// SetMaxIdleTime sets how long a connection may sit idle before it is
+// closed; zero keeps idle connections indefinitely
+func (p *ConnectionPool) SetMaxIdleTime(d time.Duration) {
+	p.mu.Lock()
+	p.maxIdleTime = d
+	p.mu.Unlock()
+	if d > 0 {
+		p.startReaper()
+	}
+}
+
+// SetMaxLifetime sets how long a connection may be reused after it was
+// created; zero reuses connections indefinitely
+func (p *ConnectionPool) SetMaxLifetime(d time.Duration) {
+	p.mu.Lock()
+	p.maxLifetime = d
+	p.mu.Unlock()
+	if d > 0 {
+		p.startReaper()
+	}
+}
+
+// SetMinIdle sets how many idle connections Warm opens and the reaper keeps
+// open, up to the pool's maximum size
+func (p *ConnectionPool) SetMinIdle(n int) {
+	if n > p.maxSize {
+		n = p.maxSize
+	}
+	p.mu.Lock()
+	p.minIdle = n
+	p.mu.Unlock()
+}
+
+// Get retrieves a connection from the pool or creates a new one. Idle
+// connections that expired or that the backend has closed are discarded.
+// It returns ErrPoolExhausted without waiting if the pool is full.
 func (p *ConnectionPool) Get() (net.Conn, error) {
+	return p.get(context.Background())
+}
+
+// get is Get with new connections dialed under ctx
+func (p *ConnectionPool) get(ctx context.Context) (net.Conn, error) {
+	for {
+		ic, ok := p.popIdle()
+		if !ok {
+			break
+		}
+		p.mu.RLock()
+		expired := p.expired(ic, time.Now())
+		p.mu.RUnlock()
+		if expired {
+			p.evict(ic.conn)
+			continue
+		}
+		live, alive := checkAlive(ic.conn)
+		if !alive {
+			p.evict(ic.conn)
+			continue
+		}
+		if live != ic.conn {
+			p.mu.Lock()
+			delete(p.created, ic.conn)
+			p.created[live] = ic.created
+			p.mu.Unlock()
+		}
+		p.hits.Add(1)
+		return live, nil
+	}
+
+	// No connections available, create a new one
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	p.misses.Add(1)
+	return p.dial(ctx)
+}
+
+// GetContext is like Get, but when the pool is full it waits for a
+// connection to be returned or a slot to free up until ctx is done.
+// Waiting callers are served in the order they arrived, and a new
+// connection must be established before ctx is done too.
+// A caller whose ctx is done while waiting gets an error matching both
+// ErrPoolExhausted and ctx's error, so a full pool can be told apart from a
+// backend that didn't answer.
+func (p *ConnectionPool) GetContext(ctx context.Context) (net.Conn, error) {
+	for {
+		conn, err := p.get(ctx)
+		if err != ErrPoolExhausted {
+			return conn, err
+		}
+		w, err := p.enqueue()
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			return p.wait(ctx, w)
+		}
+		// Capacity freed up before we could queue; try again
+	}
+}
+
+// enqueue adds a waiter to the queue, or returns nil if the pool is no
+// longer full
+func (p *ConnectionPool) enqueue() (*waiter, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if p.waiters.Len() == 0 && (len(p.idle) > 0 || p.active < p.maxSize) {
+		return nil, nil
+	}
+	w := &waiter{ready: make(chan struct{})}
+	w.elem = p.waiters.PushBack(w)
+	return w, nil
+}
+
+// wait blocks until w is granted a connection or slot or ctx is done
+func (p *ConnectionPool) wait(ctx context.Context, w *waiter) (net.Conn, error) {
+	p.waits.Add(1)
+	start := time.Now()
+	defer func() {
+		p.waitTime.Add(int64(time.Since(start)))
+	}()
+
 	select {
-	case conn := <-p.connections:
-		// Test if connection is still alive
-		if p.isConnectionValid(conn) {
-			return conn, nil
+	case <-w.ready:
+	case <-ctx.Done():
+		p.waitTimeouts.Add(1)
+		p.mu.Lock()
+		if w.elem != nil {
+			p.waiters.Remove(w.elem)
+			w.elem = nil
+			p.mu.Unlock()
+			return nil, &waitError{err: ctx.Err()}
 		}
-		// Connection is dead, create a new one
-		return p.createConnection()
-	default:
-		// No connections available, create a new one
-		return p.createConnection()
+		p.mu.Unlock()
+
+		// Granted while giving up: pass the grant on
+		<-w.ready
+		switch {
+		case w.err != nil:
+		case w.conn != nil:
+			p.Put(w.conn)
+		default:
+			p.release()
+		}
+		return nil, &waitError{err: ctx.Err()}
 	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	if w.conn != nil {
+		p.hits.Add(1)
+		return w.conn, nil
+	}
+	p.misses.Add(1)
+	return p.dial(ctx)
+}
+
+// nextWaiter removes and returns the longest waiting caller, if any.
+// Callers hold p.mu.
+func (p *ConnectionPool) nextWaiter() *waiter {
+	front := p.waiters.Front()
+	if front == nil {
+		return nil
+	}
+	w := p.waiters.Remove(front).(*waiter)
+	w.elem = nil
+	return w
+}
+
+// grant wakes a waiter with a connection, a slot (conn nil) or an error.
+// Callers hold p.mu.
+func (w *waiter) grant(conn net.Conn, err error) {
+	w.conn = conn
+	w.err = err
+	close(w.ready)
+}
+
+// freeSlot hands the slot of a closed connection to the longest waiting
+// caller, or frees it. Callers hold p.mu.
+func (p *ConnectionPool) freeSlot() {
+	if w := p.nextWaiter(); w != nil {
+		w.grant(nil, nil)
+		return
+	}
+	p.active--
 }
 
-// Put returns a connection to the pool
+// Put returns a connection to the pool. Connections past their lifetime,
+// returned after Close, or not obtained from this pool are closed.
 func (p *ConnectionPool) Put(conn net.Conn) {
 	if conn == nil {
 		return
 	}
 
-	select {
-	case p.connections <- conn:
-		// Connection added to pool
-	default:
-		// Pool is full, close the connection
-		conn.Close()
-		p.mu.Lock()
-		p.active--
+	now := time.Now()
+	p.mu.Lock()
+	created, owned := p.created[conn]
+	if owned && !p.closed && !p.lifetimeOver(created, now) {
+		if w := p.nextWaiter(); w != nil {
+			w.grant(conn, nil)
+			p.mu.Unlock()
+			return
+		}
+		p.idle = append(p.idle, idleConn{conn: conn, created: created, since: now})
 		p.mu.Unlock()
+		return
 	}
+	p.mu.Unlock()
+
+	if owned {
+		p.evictions.Add(1)
+	}
+	p.Discard(conn)
 }
 
-// Close closes all connections in the pool
+// Discard closes a connection obtained from the pool instead of returning
+// it, freeing its slot
+func (p *ConnectionPool) Discard(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	p.mu.Lock()
+	if _, ok := p.created[conn]; ok {
+		delete(p.created, conn)
+		p.freeSlot()
+	}
+	p.mu.Unlock()
+	conn.Close()
+}
+
+// Warm opens connections until the minimum number are idle, and has the
+// reaper keep that many open from then on. It returns the first dial error,
+// which also stops the reaper warming until Warm is called again, so it
+// suits being called whenever the backend becomes healthy.
+func (p *ConnectionPool) Warm() error {
+	p.mu.Lock()
+	p.warm = true
+	p.mu.Unlock()
+	p.startReaper()
+	return p.fill()
+}
+
+// Flush closes the idle connections and stops warming the pool until Warm
+// is called again, for when the backend becomes unhealthy. Connections in
+// use are unaffected.
+func (p *ConnectionPool) Flush() {
+	p.mu.Lock()
+	p.warm = false
+	idle := p.idle
+	p.idle = nil
+	for _, ic := range idle {
+		delete(p.created, ic.conn)
+		p.freeSlot()
+	}
+	p.mu.Unlock()
+
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+}
+
+// Close closes all idle connections in the pool, fails waiting callers
+// and stops the reaper. Connections in use are closed when they are
+// returned.
 func (p *ConnectionPool) Close() {
-	close(p.connections)
-	for conn := range p.connections {
-		conn.Close()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	for w := p.nextWaiter(); w != nil; w = p.nextWaiter() {
+		w.grant(nil, ErrPoolClosed)
+	}
+	idle := p.idle
+	p.idle = nil
+	for _, ic := range idle {
+		delete(p.created, ic.conn)
+		p.active--
+	}
+	p.mu.Unlock()
+
+	close(p.done)
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+}
+
+// Stats returns the pool's counters and current sizes
+func (p *ConnectionPool) Stats() Stats {
+	p.mu.RLock()
+	active, idle, waiting := p.active, len(p.idle), p.waiters.Len()
+	p.mu.RUnlock()
+	return Stats{
+		Hits:         p.hits.Load(),
+		Misses:       p.misses.Load(),
+		Evictions:    p.evictions.Load(),
+		DialErrors:   p.dialErrors.Load(),
+		Waits:        p.waits.Load(),
+		WaitTimeouts: p.waitTimeouts.Load(),
+		WaitTime:     time.Duration(p.waitTime.Load()),
+		Active:       active,
+		Idle:         idle,
+		Waiting:      waiting,
 	}
 }
 
-// createConnection creates a new connection to the backend
-func (p *ConnectionPool) createConnection() (net.Conn, error) {
+// popIdle takes the most recently returned idle connection, so that
+// connections beyond what the load needs sit idle long enough to expire
+func (p *ConnectionPool) popIdle() (idleConn, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return idleConn{}, false
+	}
+	ic := p.idle[len(p.idle)-1]
+	p.idle[len(p.idle)-1] = idleConn{}
+	p.idle = p.idle[:len(p.idle)-1]
+	if p.warm && len(p.idle) < p.minIdle {
+		// Have the reaper replace the connection in the background
+		select {
+		case p.refill <- struct{}{}:
+		default:
+		}
+	}
+	return ic, true
+}
 
-	if p.active >= p.maxSize {
-		return nil, ErrPoolExhausted
+// expired reports whether an idle connection has exceeded its idle time or
+// lifetime. Callers hold p.mu.
+func (p *ConnectionPool) expired(ic idleConn, now time.Time) bool {
+	if p.maxIdleTime > 0 && now.Sub(ic.since) >= p.maxIdleTime {
+		return true
 	}
+	return p.lifetimeOver(ic.created, now)
+}
 
-	conn, err := net.DialTimeout("tcp", p.address, 5*time.Second)
+// lifetimeOver reports whether a connection created at created has reached
+// the max lifetime. Callers hold p.mu.
+func (p *ConnectionPool) lifetimeOver(created, now time.Time) bool {
+	return p.maxLifetime > 0 && now.Sub(created) >= p.maxLifetime
+}
+
+// evict closes an idle connection the pool no longer wants
+func (p *ConnectionPool) evict(conn net.Conn) {
+	p.evictions.Add(1)
+	p.Discard(conn)
+}
+
+// reserve takes a free slot for a new connection. The slot is reserved
+// before dialing so concurrent callers cannot exceed maxSize, and none is
+// taken while callers are queued for one.
+func (p *ConnectionPool) reserve() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if p.active >= p.maxSize || p.waiters.Len() > 0 {
+		return ErrPoolExhausted
+	}
+	p.active++
+	return nil
+}
+
+// dial creates a new connection to the backend in a reserved slot
+func (p *ConnectionPool) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.dialTimeout)
+	defer cancel()
+	conn, err := dialer.Dial(ctx, p.dialer, p.address)
 	if err != nil {
+		p.dialErrors.Add(1)
+		p.release()
 		return nil, err
 	}
 
-	p.active++
+	p.mu.Lock()
+	p.created[conn] = time.Now()
+	p.mu.Unlock()
 	return conn, nil
 }
 
-// isConnectionValid checks if a connection is still valid
-func (p *ConnectionPool) isConnectionValid(conn net.Conn) bool {
-	// Set a very short deadline to test the connection
-	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
-	one := make([]byte, 1)
-	_, err := conn.Read(one)
-	conn.SetReadDeadline(time.Time{}) // Reset deadline
+// release frees a slot reserved for a connection that was never created
+func (p *ConnectionPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.freeSlot()
+}
 
-	// If we get EOF or timeout, connection might be closed
-	if err != nil {
+// startReaper starts the background goroutine that evicts expired idle
+// connections and keeps the pool warm
+func (p *ConnectionPool) startReaper() {
+	p.reaperOnce.Do(func() {
+		go p.reap()
+	})
+}
+
+func (p *ConnectionPool) reap() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refill:
+		case <-time.After(p.reapInterval()):
+			p.evictExpired(time.Now())
+		}
+		p.fill()
+	}
+}
+
+// reapInterval returns how often the reaper runs
+func (p *ConnectionPool) reapInterval() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	interval := DefaultReapInterval
+	for _, limit := range []time.Duration{p.maxIdleTime, p.maxLifetime} {
+		if limit > 0 && limit/2 < interval {
+			interval = limit / 2
+		}
+	}
+	return max(interval, minReapInterval)
+}
+
+// evictExpired closes the idle connections that exceeded their idle time
+// or lifetime
+func (p *ConnectionPool) evictExpired(now time.Time) {
+	var expired []net.Conn
+	p.mu.Lock()
+	kept := p.idle[:0]
+	for _, ic := range p.idle {
+		if p.expired(ic, now) {
+			expired = append(expired, ic.conn)
+			delete(p.created, ic.conn)
+			p.freeSlot()
+			continue
+		}
+		kept = append(kept, ic)
+	}
+	clear(p.idle[len(kept):])
+	p.idle = kept
+	p.mu.Unlock()
+
+	for _, conn := range expired {
 		conn.Close()
-		p.mu.Lock()
-		p.active--
-		p.mu.Unlock()
-		return false
 	}
+	p.evictions.Add(uint64(len(expired)))
+}
 
-	return true
+// fill dials connections until the minimum number are idle. A failed dial
+// stops warming until the next call to Warm.
+func (p *ConnectionPool) fill() error {
+	for {
+		p.mu.RLock()
+		need := p.warm && !p.closed && len(p.idle) < p.minIdle && p.active < p.maxSize
+		p.mu.RUnlock()
+		if !need {
+			return nil
+		}
+
+		if err := p.reserve(); err != nil {
+			return nil
+		}
+		conn, err := p.dial(context.Background())
+		if err != nil {
+			p.mu.Lock()
+			p.warm = false
+			p.mu.Unlock()
+			return err
+		}
+		p.Put(conn)
+	}
 }
 
 // ActiveConnections returns the number of active connections
@@ -113,12 +613,15 @@ func (p *ConnectionPool) ActiveConnections() int {
 
 // PoolSize returns the current size of the connection pool
 func (p *ConnectionPool) PoolSize() int {
-	return len(p.connections)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.idle)
 }
 
 // Error definitions
 var (
 	ErrPoolExhausted = &PoolError{"connection pool exhausted"}
+	ErrPoolClosed    = &PoolError{"connection pool closed"}
 )
 
 // PoolError represents a pool-related error
@@ -129,3 +632,21 @@ type PoolError struct {
 func (e *PoolError) Error() string {
 	return e.message
 }
+
+// waitError is returned when ctx is done while waiting for a connection
+// from a full pool
+type waitError struct {
+	err error
+}
+
+func (e *waitError) Error() string {
+	return ErrPoolExhausted.message + ": " + e.err.Error()
+}
+
+func (e *waitError) Is(target error) bool {
+	return target == ErrPoolExhausted
+}
+
+func (e *waitError) Unwrap() error {
+	return e.err
+}
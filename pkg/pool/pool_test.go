@@ -0,0 +1,666 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/testutil"
+	"l4-load-balancer/pkg/dialer"
+)
+
+// backend starts a listener that hands each accepted connection to handle
+func backend(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// hideSocket hides the connection's socket so only the read probe applies
+type hideSocket struct {
+	net.Conn
+}
+
+func TestConnectionPool_KeepsPendingData(t *testing.T) {
+	ready := make(chan struct{})
+	addr := backend(t, func(conn net.Conn) {
+		<-ready
+		conn.Write([]byte("hello"))
+	})
+
+	p := NewConnectionPool(addr, 1)
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	close(ready)
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("read %q, want %q", buf, "hello")
+	}
+}
+
+func TestCheckAlive_ReadProbeReplaysData(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		conn.Write([]byte("hello"))
+	})
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, alive := checkAlive(hideSocket{raw})
+	if !alive {
+		t.Fatal("connection with pending data reported dead")
+	}
+	// A second probe must not lose the byte held by the first
+	conn, alive = checkAlive(conn)
+	if !alive {
+		t.Fatal("connection reported dead on second probe")
+	}
+	buf := make([]byte, 5)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("read %q, want %q", buf, "hello")
+	}
+}
+
+func TestCheckAlive(t *testing.T) {
+	closed := make(chan struct{})
+	addr := backend(t, func(conn net.Conn) {
+		select {
+		case <-closed:
+			conn.Close()
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"peek", func(c net.Conn) net.Conn { return c }},
+		{"read", func(c net.Conn) net.Conn { return hideSocket{c} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			idle, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer idle.Close()
+			if _, alive := checkAlive(tt.wrap(idle)); !alive {
+				t.Error("idle connection reported dead")
+			}
+		})
+	}
+
+	close(closed)
+	for _, tt := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"peek", func(c net.Conn) net.Conn { return c }},
+		{"read", func(c net.Conn) net.Conn { return hideSocket{c} }},
+	} {
+		t.Run(tt.name+" closed", func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			time.Sleep(50 * time.Millisecond)
+			if _, alive := checkAlive(tt.wrap(conn)); alive {
+				t.Error("closed connection reported alive")
+			}
+		})
+	}
+}
+
+func TestConnectionPool_ReplacesClosedConnections(t *testing.T) {
+	var mu sync.Mutex
+	var conns []net.Conn
+	addr := backend(t, func(conn net.Conn) {
+		mu.Lock()
+		conns = append(conns, conn)
+		mu.Unlock()
+	})
+
+	p := NewConnectionPool(addr, 2)
+	a, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(a)
+	p.Put(b)
+	time.Sleep(50 * time.Millisecond)
+
+	// The backend closes both idle connections
+	mu.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get on a pool of dead idle connections: %v", err)
+	}
+	defer conn.Close()
+	if got := p.ActiveConnections(); got != 1 {
+		t.Errorf("ActiveConnections = %d, want 1", got)
+	}
+	if got := p.PoolSize(); got != 0 {
+		t.Errorf("PoolSize = %d, want 0", got)
+	}
+}
+
+func TestConnectionPool_ActiveAccounting(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	const maxSize = 4
+	p := NewConnectionPool(addr, maxSize)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				conn, err := p.Get()
+				if err == ErrPoolExhausted {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := p.ActiveConnections(); got > maxSize {
+					t.Errorf("ActiveConnections = %d, above max %d", got, maxSize)
+				}
+				if (i+j)%5 == 0 {
+					p.Discard(conn)
+				} else {
+					p.Put(conn)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if active, idle := p.ActiveConnections(), p.PoolSize(); active != idle {
+		t.Errorf("ActiveConnections = %d with %d idle and none in use", active, idle)
+	}
+}
+
+func TestConnectionPool_EvictsIdleConnections(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 2)
+	defer p.Close()
+	p.SetMaxIdleTime(50 * time.Millisecond)
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	if got := p.PoolSize(); got != 1 {
+		t.Fatalf("PoolSize = %d, want 1", got)
+	}
+
+	testutil.WaitFor(t, "idle connection eviction", func() bool { return p.PoolSize() == 0 })
+	stats := p.Stats()
+	if stats.Evictions != 1 || stats.Active != 0 {
+		t.Errorf("Stats = %+v, want 1 eviction and no active connections", stats)
+	}
+}
+
+func TestConnectionPool_MaxLifetime(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 2)
+	defer p.Close()
+	p.SetMaxLifetime(100 * time.Millisecond)
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	// A connection past its lifetime is closed rather than pooled
+	p.Put(conn)
+	if got := p.PoolSize(); got != 0 {
+		t.Errorf("PoolSize = %d after returning an expired connection, want 0", got)
+	}
+	if stats := p.Stats(); stats.Evictions != 1 || stats.Active != 0 {
+		t.Errorf("Stats = %+v, want 1 eviction and no active connections", stats)
+	}
+}
+
+func TestConnectionPool_Stats(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 2)
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	stats := p.Stats()
+	want := Stats{Hits: 1, Misses: 1, Active: 1, Idle: 1}
+	if stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestConnectionPool_Warm(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 4)
+	defer p.Close()
+	p.SetMinIdle(3)
+	if err := p.Warm(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.PoolSize(); got != 3 {
+		t.Fatalf("PoolSize = %d after Warm, want 3", got)
+	}
+
+	// The reaper replaces connections taken from the pool
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testutil.WaitFor(t, "pool to be topped up", func() bool { return p.PoolSize() == 3 })
+	if got := p.ActiveConnections(); got != 4 {
+		t.Errorf("ActiveConnections = %d, want 4", got)
+	}
+}
+
+func TestConnectionPool_WarmDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	p := NewConnectionPool(addr, 4)
+	defer p.Close()
+	p.SetMinIdle(2)
+	if err := p.Warm(); err == nil {
+		t.Fatal("Warm succeeded against a dead backend")
+	}
+
+	// Warming stops until Warm is called again
+	time.Sleep(50 * time.Millisecond)
+	if stats := p.Stats(); stats.DialErrors != 1 || stats.Active != 0 {
+		t.Errorf("Stats = %+v, want 1 dial error and no active connections", stats)
+	}
+}
+
+func TestConnectionPool_PutAfterClose(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 2)
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	p.Put(conn)
+
+	if got := p.ActiveConnections(); got != 0 {
+		t.Errorf("ActiveConnections = %d, want 0", got)
+	}
+	if _, err := p.Get(); err != ErrPoolClosed {
+		t.Errorf("Get after Close = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+// queue starts n GetContext calls one after another, each waiting before
+// the next starts, and returns their results in arrival order
+func queue(t *testing.T, p *ConnectionPool, n int) []chan net.Conn {
+	t.Helper()
+	results := make([]chan net.Conn, n)
+	for i := range results {
+		results[i] = make(chan net.Conn, 1)
+		go func(ch chan net.Conn) {
+			conn, err := p.GetContext(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			ch <- conn
+		}(results[i])
+		testutil.WaitFor(t, "caller to queue", func() bool { return p.Stats().Waiting == i+1 })
+	}
+	return results
+}
+
+func TestConnectionPool_GetContextFIFO(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 1)
+	defer p.Close()
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := queue(t, p, 3)
+	if _, err := p.Get(); err != ErrPoolExhausted {
+		t.Errorf("Get with callers queued = %v, want %v", err, ErrPoolExhausted)
+	}
+
+	// Each returned connection goes to the longest waiting caller
+	conn := held
+	for i, ch := range results {
+		p.Put(conn)
+		select {
+		case conn = <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("caller %d was not served", i)
+		}
+		if conn != held {
+			t.Errorf("caller %d got a new connection instead of the returned one", i)
+		}
+		for j := i + 1; j < len(results); j++ {
+			if len(results[j]) > 0 {
+				t.Fatalf("caller %d served before caller %d", j, i)
+			}
+		}
+	}
+	p.Put(conn)
+
+	stats := p.Stats()
+	if stats.Waits != 3 || stats.Waiting != 0 || stats.Active != 1 {
+		t.Errorf("Stats = %+v, want 3 waits, none waiting and 1 active", stats)
+	}
+}
+
+func TestConnectionPool_GetContextFreedSlot(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 1)
+	defer p.Close()
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := queue(t, p, 1)
+	p.Discard(held)
+	select {
+	case conn := <-results[0]:
+		if conn == nil || conn == held {
+			t.Fatal("waiting caller did not get a new connection")
+		}
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("caller was not served after a slot freed up")
+	}
+	if got := p.ActiveConnections(); got != 1 {
+		t.Errorf("ActiveConnections = %d, want 1", got)
+	}
+}
+
+func TestConnectionPool_GetContextTimeout(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 1)
+	defer p.Close()
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.GetContext(ctx); !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("GetContext = %v, want %v and %v", err, ErrPoolExhausted, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("GetContext returned after %v, before the deadline", waited)
+	}
+
+	stats := p.Stats()
+	if stats.Waits != 1 || stats.WaitTimeouts != 1 || stats.Waiting != 0 || stats.WaitTime < 50*time.Millisecond {
+		t.Errorf("Stats = %+v, want 1 timed out wait of at least 50ms", stats)
+	}
+}
+
+// stallDialer never connects, returning only when the dial is cancelled
+type stallDialer struct{}
+
+func (stallDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestConnectionPool_GetContextDialDeadline(t *testing.T) {
+	p := NewConnectionPool("backend:1", 1)
+	defer p.Close()
+	p.SetDialer(stallDialer{})
+
+	// The caller's deadline bounds the dial, not just the pool's dial timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.GetContext(ctx); !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("GetContext = %v, want a dial timeout", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("GetContext returned after %v, want the caller's deadline", waited)
+	}
+	if got := p.ActiveConnections(); got != 0 {
+		t.Errorf("ActiveConnections = %d after a failed dial, want 0", got)
+	}
+}
+
+func TestConnectionPool_CloseFailsWaiters(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 1)
+	held, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := p.GetContext(context.Background())
+		errs <- err
+	}()
+	testutil.WaitFor(t, "caller to queue", func() bool { return p.Stats().Waiting == 1 })
+	p.Close()
+
+	select {
+	case err := <-errs:
+		if err != ErrPoolClosed {
+			t.Errorf("GetContext = %v, want %v", err, ErrPoolClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting caller not woken by Close")
+	}
+}
+
+func TestConnectionPool_CloseDuringPut(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 8)
+	conns := make([]net.Conn, 8)
+	for i := range conns {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+	}
+
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Put(conn)
+		}()
+	}
+	p.Close()
+	wg.Wait()
+
+	if got := p.ActiveConnections(); got != 0 {
+		t.Errorf("ActiveConnections = %d after Close, want 0", got)
+	}
+	if got := p.PoolSize(); got != 0 {
+		t.Errorf("PoolSize = %d after Close, want 0", got)
+	}
+}
+
+func TestConnectionPool_Flush(t *testing.T) {
+	addr := backend(t, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	p := NewConnectionPool(addr, 4)
+	defer p.Close()
+	p.SetMinIdle(2)
+	if err := p.Warm(); err != nil {
+		t.Fatal(err)
+	}
+	inUse, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+	testutil.WaitFor(t, "pool to be topped up", func() bool { return p.PoolSize() == 2 })
+
+	p.Flush()
+	if got := p.PoolSize(); got != 0 {
+		t.Errorf("PoolSize = %d after Flush, want 0", got)
+	}
+	if got := p.ActiveConnections(); got != 1 {
+		t.Errorf("ActiveConnections = %d after Flush, want the 1 in use", got)
+	}
+
+	// Flushing stops warming
+	time.Sleep(50 * time.Millisecond)
+	if got := p.PoolSize(); got != 0 {
+		t.Errorf("PoolSize = %d after Flush, pool was warmed again", got)
+	}
+}
+
+func TestConnectionPool_Dialer(t *testing.T) {
+	pipe := dialer.NewPipe()
+	defer pipe.Close()
+	go func() {
+		for {
+			conn, err := pipe.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	p := NewConnectionPool("backend:1", 1)
+	defer p.Close()
+	p.SetDialer(pipe)
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	// The idle pipe is checked with the read probe and reused
+	conn, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if stats := p.Stats(); stats.Hits != 1 {
+		t.Errorf("Stats = %+v, want the pipe reused", stats)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("read %q, %v, want %q", buf, err, "ping")
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+	"l4-load-balancer/internal/health"
+	"l4-load-balancer/internal/metrics"
+)
+
+// startEchoServer runs a TCP server echoing everything it receives
+func startEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	manager := backend.NewManager()
+	addr := echo.Addr().(*net.TCPAddr)
+	manager.AddServer("127.0.0.1", addr.Port)
+	checker := health.NewPoolChecker("test", manager, time.Hour, time.Second)
+	go checker.Start()
+	defer checker.Stop()
+
+	lb := balancer.NewLoadBalancer("", []balancer.Backend{
+		{Address: echo.Addr().String(), Healthy: true},
+	}, balancer.NewRoundRobinAlgorithm())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	io.ReadFull(conn, buf)
+	conn.(*net.TCPConn).CloseWrite()
+	io.Copy(io.Discard, conn)
+	conn.Close()
+
+	listenerLabel := listener.Addr().String()
+	backendLabel := echo.Addr().String()
+	expected := []string{
+		fmt.Sprintf(`lb_connections_accepted_total{listener="%s"} 1`, listenerLabel),
+		fmt.Sprintf(`lb_connections_active{listener="%s"} 0`, listenerLabel),
+		fmt.Sprintf(`lb_connections_closed_total{listener="%s",reason="client_closed"} 1`, listenerLabel),
+		fmt.Sprintf(`lb_backend_bytes_total{pool="default",backend="%s",direction="in"} 5`, backendLabel),
+		fmt.Sprintf(`lb_backend_bytes_total{pool="default",backend="%s",direction="out"} 5`, backendLabel),
+		fmt.Sprintf(`lb_backend_connection_duration_seconds_count{pool="default",backend="%s"} 1`, backendLabel),
+		fmt.Sprintf(`lb_health_checks_total{pool="test",backend="%s",result="success"} 1`, backendLabel),
+		fmt.Sprintf(`lb_backend_up{pool="test",backend="%s"} 1`, backendLabel),
+	}
+
+	// The session finishes asynchronously after the client sees EOF
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		body := scrape(t)
+		missing := ""
+		for _, line := range expected {
+			if !strings.Contains(body, line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Metric %q not found in:\n%s", missing, body)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Removing the backend drops its health series
+	manager.RemoveServer(backendLabel)
+	if body := scrape(t); strings.Contains(body, fmt.Sprintf(`lb_backend_up{pool="test",backend="%s"}`, backendLabel)) {
+		t.Errorf("Expected the removed backend's health series to be gone:\n%s", body)
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"l4-load-balancer/internal/backend"
+	"l4-load-balancer/internal/balancer"
+)
+
+func TestTLSOriginationToBackend(t *testing.T) {
+	// Start a TLS-only backend
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "tls=%v", r.TLS != nil)
+	}))
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	tlsConfig := &tls.Config{RootCAs: roots}
+
+	host, portStr, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	// Health probes should succeed only with the right TLS settings
+	server := &backend.Server{Address: host, Port: port, TLSConfig: tlsConfig}
+	if !server.IsReachable() {
+		t.Fatal("Expected TLS backend to be reachable with trusted CA")
+	}
+	untrusted := &backend.Server{Address: host, Port: port, TLSConfig: &tls.Config{}}
+	if untrusted.IsReachable() {
+		t.Error("Expected TLS handshake to fail without trusted CA")
+	}
+
+	lb := balancer.NewLoadBalancer("", []balancer.Backend{
+		{Address: ts.Listener.Addr().String(), Healthy: true, TLSConfig: tlsConfig},
+	}, balancer.NewRoundRobinAlgorithm())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go lb.Serve(listener)
+	defer lb.Close()
+
+	// The client speaks plaintext HTTP to the load balancer
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("Request through load balancer failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "tls=true" {
+		t.Errorf("Expected backend to see a TLS connection, got %q", body)
+	}
+}